@@ -0,0 +1,122 @@
+package invasion
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// asciiMaxName is a max number of characters from the name of the city that are printed.
+	asciiMaxName = 12
+	// asciiGap is a number of characters between cells in the row.
+	asciiGap = 3
+)
+
+// WriteASCII prints the map as a text picture. Cities are placed on a character grid using NewLayout,
+// routes between neighbours are drawn with - and |. Routes that can't be drawn in their direction
+// are listed after the picture.
+func (m *Map) WriteASCII(w io.Writer) error {
+	return writeASCII(w, newScene(m, nil, nil))
+}
+
+// WriteASCII prints current state of the invasion as a text picture. Aliens are shown after the name of
+// the city, e.g. Foo@1,2 for aliens 1 and 2, Foo!1 if alien 1 is trapped. Destroyed cities are
+// prefixed with x and their routes are drawn with . and :.
+func (si *simulation) WriteASCII(w io.Writer) error {
+	return writeASCII(w, newScene(si.m, si.ruins, si.located()))
+}
+
+func asciiLabel(s *scene, c *City) []rune {
+	name := []rune(c.Name)
+	if len(name) > asciiMaxName {
+		name = append(name[:asciiMaxName-1], '~')
+	}
+	label := string(name)
+	switch s.states[c.ID] {
+	case stateDestroyed:
+		label = "x" + label
+	case stateTrapped:
+		label += "!" + aliensList(s.aliens[c.ID])
+	case stateInvaded:
+		label += "@" + aliensList(s.aliens[c.ID])
+	}
+	return []rune(label)
+}
+
+func aliensList(ids []int) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprintf("%d", id)
+	}
+	return strings.Join(parts, ",")
+}
+
+func writeASCII(w io.Writer, s *scene) error {
+	var (
+		l      = NewLayout(s.m)
+		buf    = bufio.NewWriter(w)
+		labels = make(map[string][]rune, s.m.Size())
+		width  = 1
+	)
+	s.m.IterateCities(func(c *City, _ []Route) bool {
+		labels[c.ID] = asciiLabel(s, c)
+		if len(labels[c.ID]) > width {
+			width = len(labels[c.ID])
+		}
+		return true
+	})
+	if s.m.Size() == 0 {
+		return buf.Flush()
+	}
+
+	// every cell is followed by a gap, and every row by a line for vertical routes
+	canvas := make([][]rune, 2*l.Height-1)
+	for i := range canvas {
+		canvas[i] = []rune(strings.Repeat(" ", l.Width*(width+asciiGap)))
+	}
+	column := func(p Point) int {
+		return p.X * (width + asciiGap)
+	}
+	s.m.IterateCities(func(c *City, routes []Route) bool {
+		pos := l.Positions[c.ID]
+		copy(canvas[2*pos.Y][column(pos):], labels[c.ID])
+		for _, r := range routes {
+			// only routes to the east and to the south are drawn, to draw every route once
+			if pos.Move(r.Direction) != l.Positions[r.To] {
+				continue
+			}
+			destroyed := s.destroyedRoute(c.ID, r)
+			switch r.Direction {
+			case East:
+				fill := '-'
+				if destroyed {
+					fill = '.'
+				}
+				start := column(pos) + len(labels[c.ID])
+				end := column(pos) + width + asciiGap
+				for i := start; i < end; i++ {
+					canvas[2*pos.Y][i] = fill
+				}
+			case South:
+				fill := '|'
+				if destroyed {
+					fill = ':'
+				}
+				canvas[2*pos.Y+1][column(pos)] = fill
+			}
+		}
+		return true
+	})
+	for _, line := range canvas {
+		fmt.Fprintln(buf, strings.TrimRight(string(line), " "))
+	}
+	if len(l.Inconsistent) > 0 {
+		fmt.Fprintf(buf, "\n%d routes can't be drawn in their direction:\n", len(l.Inconsistent))
+		for _, e := range l.Inconsistent {
+			fmt.Fprintf(buf, "%s %v=%s\n", s.m.GetCity(e.From).Name, e.Direction, s.m.GetCity(e.To).Name)
+		}
+	}
+	return buf.Flush()
+}
@@ -0,0 +1,278 @@
+package invasion
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+)
+
+const checkpointVersion = 1
+
+// ErrInvalidCheckpoint returned if checkpoint can't be used to resume the simulation.
+var ErrInvalidCheckpoint = errors.New("Invalid checkpoint")
+
+// checkpoint is a full state of the SerialInvasion:
+//
+//	{
+//	  "version": 1,
+//	  "rng": "AQAAAAAAAAB7AAAAAAAAAAE=",
+//	  "max_moves": 100,
+//	  "step": 60,
+//	  "fight_threshold": 2,
+//	  "fight": "destroy",
+//	  "movement": "uniform",
+//	  "alien_movements": {"3": "seek"},
+//	  "map": {"cities": [...]},
+//	  "aliens": [{"id": 0, "moves": 30, "location": "foo"}],
+//	  "aliens_order": [0],
+//	  "cities_order": ["bar", "foo"],
+//	  "ruins": [{"id": "baz", "name": "Baz", "occupants": [2, 1], "destroyed": true, "routes": []}],
+//	  "fallen": [{"id": 1, "moves": 12, "dead": true, "location": "baz", "history": {"limit": 0, "visits": [...]}}]
+//	}
+type checkpoint struct {
+	Version     int         `json:"version"`
+	RNG         []byte      `json:"rng"`
+	MaxMoves    int         `json:"max_moves"`
+	Step        int         `json:"step"`
+	Dead        int         `json:"dead,omitempty"`
+	Budget      int         `json:"budget,omitempty"`
+	Threshold   int         `json:"fight_threshold"`
+	Fight       string      `json:"fight"`
+	Movement    string      `json:"movement"`
+	Map         *Map        `json:"map"`
+	Aliens      []jsonAlien `json:"aliens"`
+	AliensOrder []int       `json:"aliens_order"`
+	CitiesOrder []string    `json:"cities_order"`
+	Ruins       []jsonCity  `json:"ruins,omitempty"`
+	Fallen      []jsonAlien `json:"fallen,omitempty"`
+
+	// Movements are strategies of the aliens that don't use the global one.
+	Movements map[int]string `json:"alien_movements,omitempty"`
+}
+
+type jsonAlien struct {
+	ID       int    `json:"id"`
+	Moves    int    `json:"moves"`
+	Dead     bool   `json:"dead,omitempty"`
+	Location string `json:"location,omitempty"`
+	Trapped  bool   `json:"trapped,omitempty"`
+	Strength int    `json:"strength,omitempty"`
+	Faction  string `json:"faction,omitempty"`
+
+	History *History `json:"history,omitempty"`
+}
+
+func newJSONAlien(a *Alien) jsonAlien {
+	return jsonAlien{
+		ID:       a.ID,
+		Moves:    a.Moves,
+		Dead:     a.Dead,
+		Location: a.Location,
+		Trapped:  a.Trapped,
+		Strength: a.Strength,
+		Faction:  a.Faction,
+		History:  a.History,
+	}
+}
+
+func (ja jsonAlien) alien() *Alien {
+	return &Alien{
+		ID:       ja.ID,
+		Moves:    ja.Moves,
+		Dead:     ja.Dead,
+		Location: ja.Location,
+		Trapped:  ja.Trapped,
+		Strength: ja.Strength,
+		Faction:  ja.Faction,
+		History:  ja.History,
+	}
+}
+
+// resolverName returns the name of the resolver that ParseFightResolver turns back into the same resolver.
+func resolverName(r FightResolver) (string, error) {
+	if s, ok := r.(fmt.Stringer); ok {
+		if parsed, err := ParseFightResolver(s.String()); err == nil && reflect.DeepEqual(parsed, r) {
+			return s.String(), nil
+		}
+	}
+	return "", fmt.Errorf("%w: fight resolver %T can't be saved", ErrInvalidCheckpoint, r)
+}
+
+// movementName returns the name of the strategy that ParseMovementStrategy turns back into the same strategy.
+func movementName(s MovementStrategy) (string, error) {
+	if named, ok := s.(fmt.Stringer); ok {
+		if parsed, err := ParseMovementStrategy(named.String()); err == nil && reflect.DeepEqual(parsed, s) {
+			return named.String(), nil
+		}
+	}
+	return "", fmt.Errorf("%w: movement strategy %T can't be saved", ErrInvalidCheckpoint, s)
+}
+
+// Checkpoint writes full state of the simulation to w, including the state of the random generator.
+// Random generator must be a *PCG, as the state of other generators can't be saved.
+// Fight resolver and movement strategies are saved by name, so only the ones that can be parsed
+// by ParseFightResolver and ParseMovementStrategy can be used.
+// Checkpoint is consistent only between steps, it must not be called concurrently with Step or Run.
+func (si *SerialInvasion) Checkpoint(w io.Writer) error {
+	pcg, ok := si.r.(*PCG)
+	if !ok {
+		return fmt.Errorf("%w: state of %T can't be saved", ErrInvalidCheckpoint, si.r)
+	}
+	state, err := pcg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	fight, err := resolverName(si.resolver)
+	if err != nil {
+		return err
+	}
+	movement, err := movementName(si.movement)
+	if err != nil {
+		return err
+	}
+	movements := make(map[int]string, len(si.movements))
+	for id, s := range si.movements {
+		movements[id], err = movementName(s)
+		if err != nil {
+			return err
+		}
+	}
+	cp := checkpoint{
+		Version:     checkpointVersion,
+		RNG:         state,
+		MaxMoves:    si.maxMoves,
+		Step:        si.step,
+		Dead:        si.dead,
+		Budget:      si.budget,
+		Threshold:   si.threshold,
+		Fight:       fight,
+		Movement:    movement,
+		Movements:   movements,
+		Map:         si.m,
+		Aliens:      make([]jsonAlien, 0, len(si.aliens)),
+		AliensOrder: si.aliensOrder,
+		CitiesOrder: si.citiesOrder,
+	}
+	for _, a := range si.aliens {
+		cp.Aliens = append(cp.Aliens, newJSONAlien(a))
+	}
+	sort.Slice(cp.Aliens, func(i, j int) bool {
+		return cp.Aliens[i].ID < cp.Aliens[j].ID
+	})
+	for _, a := range si.fallen {
+		cp.Fallen = append(cp.Fallen, newJSONAlien(a))
+	}
+	sort.Slice(cp.Fallen, func(i, j int) bool {
+		return cp.Fallen[i].ID < cp.Fallen[j].ID
+	})
+	for _, ruin := range si.ruins {
+		jc := jsonCity{
+			ID:        ruin.City.ID,
+			Name:      ruin.City.Name,
+			Occupants: ruin.City.Occupants,
+			Destroyed: ruin.City.Destroyed,
+			Damage:    ruin.City.Damage,
+			Routes:    ruin.Routes,
+		}
+		if jc.Routes == nil {
+			jc.Routes = []Route{}
+		}
+		cp.Ruins = append(cp.Ruins, jc)
+	}
+	return json.NewEncoder(w).Encode(cp)
+}
+
+// ResumeSerialInvasion restores simulation from the checkpoint written by SerialInvasion.Checkpoint.
+// Random generator is restored exactly where it was when checkpoint was written, so the resumed
+// simulation emits the same events as the simulation that was never interrupted.
+// Fight resolver and movement strategies are restored as well. Sinks and stop conditions are not a part
+// of the checkpoint, notifier is used same as in NewSerialInvasion.
+func ResumeSerialInvasion(r io.Reader, notifier io.Writer) (*SerialInvasion, error) {
+	cp := checkpoint{Map: NewMap()}
+	if err := json.NewDecoder(r).Decode(&cp); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCheckpoint, err)
+	}
+	if cp.Version != checkpointVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidCheckpoint, cp.Version)
+	}
+	pcg := &PCG{}
+	if err := pcg.UnmarshalBinary(cp.RNG); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCheckpoint, err)
+	}
+	if err := cp.Map.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCheckpoint, err)
+	}
+	if cp.Threshold < 2 {
+		return nil, fmt.Errorf("%w: fight threshold %d is lower than 2", ErrInvalidCheckpoint, cp.Threshold)
+	}
+
+	aliens := make(map[int]*Alien, len(cp.Aliens))
+	for _, ja := range cp.Aliens {
+		if len(ja.Location) > 0 && !ja.Dead && cp.Map.GetCity(ja.Location) == nil {
+			return nil, fmt.Errorf("%w: alien %d is in unknown city %s", ErrInvalidCheckpoint, ja.ID, ja.Location)
+		}
+		aliens[ja.ID] = ja.alien()
+	}
+	var err error
+	cp.Map.IterateCities(func(c *City, _ []Route) bool {
+		for _, id := range c.Occupants {
+			if a, exist := aliens[id]; !exist || a.Dead || a.Location != c.ID {
+				err = fmt.Errorf("%w: alien %d is not in the city %s", ErrInvalidCheckpoint, id, c.ID)
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range cp.AliensOrder {
+		if _, exist := aliens[id]; !exist {
+			return nil, fmt.Errorf("%w: unknown alien %d in the order", ErrInvalidCheckpoint, id)
+		}
+	}
+	for _, id := range cp.CitiesOrder {
+		if cp.Map.GetCity(id) == nil {
+			return nil, fmt.Errorf("%w: unknown city %s in the order", ErrInvalidCheckpoint, id)
+		}
+	}
+	resolver, err := ParseFightResolver(cp.Fight)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCheckpoint, err)
+	}
+	movement, err := ParseMovementStrategy(cp.Movement)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCheckpoint, err)
+	}
+	movements := make(map[int]MovementStrategy, len(cp.Movements))
+	for id, name := range cp.Movements {
+		movements[id], err = ParseMovementStrategy(name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: alien %d: %v", ErrInvalidCheckpoint, id, err)
+		}
+	}
+
+	si := NewSerialInvasion(cp.Map, pcg, notifier, 0, cp.MaxMoves)
+	si.aliens = aliens
+	for _, ja := range cp.Fallen {
+		si.fallen[ja.ID] = ja.alien()
+	}
+	si.aliensOrder = cp.AliensOrder
+	si.citiesOrder = cp.CitiesOrder
+	si.step = cp.Step
+	si.dead = cp.Dead
+	si.budget = cp.Budget
+	si.threshold = cp.Threshold
+	si.resolver = resolver
+	// history is restored with aliens, so strategies are set directly
+	si.movement = movement
+	si.movements = movements
+	for _, jc := range cp.Ruins {
+		city := &City{ID: jc.ID, Name: jc.Name, Occupants: jc.occupants(), Destroyed: jc.Destroyed, Damage: jc.Damage}
+		si.ruins = append(si.ruins, Ruin{City: city, Routes: jc.Routes})
+	}
+	return si, nil
+}
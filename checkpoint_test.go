@@ -0,0 +1,127 @@
+package invasion
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpointResumeReproducible(t *testing.T) {
+	data := mapText(t, GenerateMap(rand.New(rand.NewSource(5)), 300, 400))
+
+	uninterrupted := &collectSink{}
+	inv := NewSerialInvasion(NewMapFromString(data), NewPCG(5, 0), ioutil.Discard, 40, 200)
+	inv.AddSink(uninterrupted)
+	_, err := inv.Run(context.Background())
+	require.NoError(t, err)
+
+	for _, steps := range []int{0, 1, 100, 1000} {
+		collected := &collectSink{}
+		first := NewSerialInvasion(NewMapFromString(data), NewPCG(5, 0), ioutil.Discard, 40, 200)
+		first.AddSink(collected)
+		_, err := first.RunSteps(context.Background(), steps)
+		require.NoError(t, err)
+
+		buf := bytes.NewBuffer(nil)
+		require.NoError(t, first.Checkpoint(buf))
+		resumed, err := ResumeSerialInvasion(buf, ioutil.Discard)
+		require.NoError(t, err)
+		resumed.AddSink(collected)
+		_, err = resumed.Run(context.Background())
+		require.NoError(t, err)
+
+		require.Equal(t, uninterrupted.evs, collected.evs, "checkpoint after %d steps", steps)
+		require.Equal(t, mapText(t, inv.m), mapText(t, resumed.m))
+		require.Equal(t, inv.Ruins(), resumed.Ruins())
+	}
+}
+
+// resume checkpoints serial invasion and resumes it from the checkpoint.
+func resume(t testing.TB, inv Invasion) *SerialInvasion {
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, inv.(*SerialInvasion).Checkpoint(buf))
+	resumed, err := ResumeSerialInvasion(buf, ioutil.Discard)
+	require.NoError(t, err)
+	return resumed
+}
+
+func TestCheckpointFightThreshold(t *testing.T) {
+	inv := NewInvasion(GenerateMap(rand.New(rand.NewSource(6)), 50, 60), WithAliens(20), WithFightThreshold(4))
+	_, err := inv.RunSteps(context.Background(), 20)
+	require.NoError(t, err)
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, inv.(*SerialInvasion).Checkpoint(buf))
+	resumed, err := ResumeSerialInvasion(buf, ioutil.Discard)
+	require.NoError(t, err)
+	require.Equal(t, 4, resumed.threshold)
+	require.Equal(t, mapText(t, inv.Map()), mapText(t, resumed.Map()))
+	require.NoError(t, VerifyInvariants(resumed.Map(), resumed.Aliens()))
+}
+
+func TestCheckpointRequiresPCG(t *testing.T) {
+	inv := NewSerialInvasion(NewMapFromString("Foo\n"), rand.New(rand.NewSource(1)), ioutil.Discard, 1, 1)
+	err := inv.Checkpoint(ioutil.Discard)
+	require.True(t, errors.Is(err, ErrInvalidCheckpoint), "error is %v", err)
+}
+
+func TestResumeInvalidCheckpoint(t *testing.T) {
+	state, err := NewPCG(1, 0).MarshalBinary()
+	require.NoError(t, err)
+	rng := base64.StdEncoding.EncodeToString(state)
+	for _, data := range []string{
+		`{"version": 1`,
+		`{"version": 2, "map": {"cities": []}}`,
+		`{"version": 1, "rng": "AQ==", "fight_threshold": 2, "map": {"cities": []}}`,
+		fmt.Sprintf(`{"version": 1, "rng": %q, "fight_threshold": 2, "map": {"cities": []}, "aliens": [{"id": 0, "location": "foo"}]}`, rng),
+		fmt.Sprintf(`{"version": 1, "rng": %q, "fight_threshold": 2, "map": {"cities": []}, "aliens_order": [1]}`, rng),
+		fmt.Sprintf(`{"version": 1, "rng": %q, "fight_threshold": 2, "map": {"cities": []}, "cities_order": ["foo"]}`, rng),
+		fmt.Sprintf(`{"version": 1, "rng": %q, "fight_threshold": 1, "map": {"cities": []}}`, rng),
+		fmt.Sprintf(`{"version": 1, "rng": %q, "fight_threshold": 2, "map": {"cities": [{"id": "foo", "name": "Foo", "occupants": [0], "routes": []}]}}`, rng),
+		fmt.Sprintf(`{"version": 1, "rng": %q, "fight_threshold": 2, "fight": "peace", "movement": "uniform", "map": {"cities": []}}`, rng),
+		fmt.Sprintf(`{"version": 1, "rng": %q, "fight_threshold": 2, "fight": "destroy", "movement": "teleport", "map": {"cities": []}}`, rng),
+	} {
+		_, err := ResumeSerialInvasion(strings.NewReader(data), ioutil.Discard)
+		require.True(t, errors.Is(err, ErrInvalidCheckpoint), "%s: %v", data, err)
+	}
+}
+
+// impostor claims to be DestroyAll, but lets every alien survive. As a movement strategy it can't be saved either.
+type impostor struct{}
+
+func (impostor) Resolve(f Fight) Outcome {
+	return Outcome{Survivors: f.Aliens}
+}
+
+func (impostor) String() string {
+	return "destroy"
+}
+
+func (impostor) Move(alien *Alien, m MapView, r RNG) (Route, bool) {
+	return Route{}, false
+}
+
+func TestCheckpointStrategies(t *testing.T) {
+	data := mapText(t, GenerateMap(rand.New(rand.NewSource(7)), 50, 60))
+	inv := NewInvasion(NewMapFromString(data), WithSeed(7), WithAliens(20), WithMoves(50),
+		WithFightResolver(DamageCity(2)), WithMovement(Lazy(0.5)))
+	_, err := inv.RunSteps(context.Background(), 100)
+	require.NoError(t, err)
+	resumed := resume(t, inv)
+	require.Equal(t, DamageCity(2), resumed.resolver)
+	require.Equal(t, Lazy(0.5), resumed.movement)
+
+	for _, opt := range []Option{WithFightResolver(impostor{}), WithMovement(impostor{})} {
+		inv := NewInvasion(NewMapFromString(data), WithAliens(20), opt)
+		err := inv.(*SerialInvasion).Checkpoint(ioutil.Discard)
+		require.True(t, errors.Is(err, ErrInvalidCheckpoint), "error is %v", err)
+	}
+}
@@ -2,20 +2,46 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
-	"math/rand"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dshulyak/invasion"
 )
 
 var (
-	aliens = flag.Int("n", 100, "number of aliens that invade the world")
-	moves  = flag.Int("m", 10000, "max number of moves every alien can make")
-	seed   = flag.Int64("seed", time.Now().UnixNano(), "provided seed will be used for simulation")
+	aliens          = flag.Int("n", 100, "number of aliens that invade the world")
+	moves           = flag.Int("m", 10000, "max number of moves every alien can make")
+	seed            = flag.Int64("seed", time.Now().UnixNano(), "provided seed will be used for simulation")
+	format          = flag.String("format", "text", "format of the input and output maps, text or json")
+	dot             = flag.String("dot", "", "if provided, result of the simulation will be saved to this file in graphviz DOT format.")
+	svg             = flag.String("svg", "", "if provided, result of the simulation will be drawn to this file as SVG image.")
+	events          = flag.String("events", "", "if provided, every event of the simulation will be saved to this file in JSON Lines format. file will be truncated.")
+	checkpoint      = flag.String("checkpoint", "", "if provided, state of the simulation will be saved to this file every -checkpoint-every steps and when simulation ends.")
+	checkpointEvery = flag.Int("checkpoint-every", 100000, "number of steps between checkpoints")
+	resume          = flag.String("resume", "", "if provided, simulation will be resumed from this checkpoint. map file, -n, -m, -seed and -engine are ignored.")
+	engine          = flag.String("engine", "serial", "simulation engine, serial or parallel. parallel engine moves all aliens in rounds and doesn't support checkpoints.")
+	workers         = flag.Int("workers", 0, "number of workers for the parallel engine, all CPUs by default")
+	fight           = flag.String("fight", "destroy", "how fights are resolved: destroy (all aliens die and the city is destroyed), survivor (one random alien survives), strongest (the strongest alien survives), merge (aliens merge into one) or damage:N (city is destroyed in the N-th fight). restored from the checkpoint when simulation is resumed.")
+	movement        = flag.String("movement", "uniform", "how aliens pick routes: uniform, lazy:P (stays in the city with probability P), bias:DIRECTION[:WEIGHT] (prefers the direction), avoid-visited or seek (moves towards the nearest alien that is at most 10 routes away). restored from the checkpoint when simulation is resumed.")
+	alienMovement   = flag.String("alien-movement", "", "comma separated movement strategies for specific aliens, e.g. 0=seek,3=lazy:0.5. restored from the checkpoint when simulation is resumed.")
+	history         = flag.Int("history", -1, "if provided, at most this number of visited cities is recorded for every alien, 0 records all visited cities, negative values disable history. restored from the checkpoint when simulation is resumed.")
+	historyOut      = flag.String("history-out", "", "if provided, path of every alien recorded with -history will be saved to this file in JSON Lines format.")
+	factions        = flag.String("factions", "", "if provided, aliens are split into factions, e.g. red:50,blue:50. aliens of the same faction don't fight, -n is ignored. restored from the checkpoint when simulation is resumed.")
+	fightThreshold  = flag.Int("fight-threshold", invasion.DefaultFightThreshold, "number of aliens in the same city that start a fight. restored from the checkpoint when simulation is resumed.")
+	budget          = flag.Int("budget", 0, "max number of simulation steps (rounds for the parallel engine), 0 doesn't limit steps")
+	stopDestroyed   = flag.Float64("stop-destroyed", 0, "if provided, simulation stops when this fraction of the cities (greater than 0 and at most 1) is destroyed")
+	stopCity        = flag.String("stop-city", "", "if provided, simulation stops when this city is destroyed")
+	stopTrapped     = flag.Bool("stop-trapped", false, "stop simulation when none of the aliens can move, instead of waiting until trapped aliens make all moves")
+	stopIdle        = flag.Int("stop-idle", 0, "if provided, simulation stops when nothing happened in this number of steps")
 	// TODO replace with positional
 	out = flag.String("out", "", "after simulation updated map will be saved to this file, otherwise printed to stdout. file will be truncated.")
 
@@ -26,20 +52,42 @@ Tot-H east=Bar
 Baz north=Foo123
 Bar
 
-Each line should start with a city as a word without empty spaces, any characters except '=' are allowed.
+Each line should start with a city name, a word without empty spaces and '=' that doesn't start with '"', '#' or '@'.
+Other names should be enclosed in double quotes, e.g. "New York" north="Los Angeles". Inside the quotes \" escapes a quote
+and \\ escapes a backslash. Names can't have new lines.
 At most four directions should follow the city name, zero is fine too.
 Each direction should be in <key>=<value> format without empty spaces in the middle.
 Directions should be symmetric, e.g. if Foo123 has a Baz in the south, Baz should have Foo123 in the north. Such relationships
 doesn't have to be specified for every pair, the program will restore them automatically.
 If Bar defines direction to Foo123 - it can't be north, as it will conflict with Baz.
+Empty lines and comments that start with # are ignored. Map may start with headers in @key value format.
 
 Usage:
 
 invasion <your.map>
+invasion -resume=<checkpoint>
+invasion render <your.map>
 
 Examples:
 invasion -out=./_assets/rst-1000-500.out ./_assets/1000-500.out
 invasion -seed=777 ./_assets/1000-500.out
+invasion -engine=parallel -workers=4 ./_assets/1000-500.out
+invasion -checkpoint=./_assets/1000-500.checkpoint ./_assets/1000-500.out
+invasion -resume=./_assets/1000-500.checkpoint -checkpoint=./_assets/1000-500.checkpoint
+invasion render -steps=10 -n 4 your.map
+
+Defaults:`
+
+	renderUsage = `Render a map, optionally after executing a number of simulation steps.
+
+Usage:
+
+invasion render [flags] <your.map>
+
+Examples:
+invasion render your.map
+invasion render -n 4 -steps=10 your.map
+invasion render -as=svg -steps=-1 your.map > your.svg
 
 Defaults:`
 )
@@ -51,29 +99,128 @@ func main() {
 		flag.PrintDefaults()
 	}
 	flag.Parse()
-	if len(flag.Args()) < 1 {
+	if flag.Arg(0) == "render" {
+		render(flag.Args()[1:])
+		return
+	}
+	if len(flag.Args()) < 1 && len(*resume) == 0 {
 		log.Fatalf("program expects first positional argument to be a file")
 	}
-
-	in := flag.Arg(0)
-	f, err := os.OpenFile(in, os.O_RDONLY, 0600)
+	mapFormat, err := invasion.ParseFormat(*format)
 	if err != nil {
-		log.Fatalf("failed to open a file %s: %v", in, err)
+		log.Fatalf("%v", err)
 	}
-	defer f.Close()
-	buf := bufio.NewReader(f)
 
-	m := invasion.NewMap()
-	_, err = m.ReadFrom(buf)
-	if err != nil {
-		log.Fatalf("failed to fill the map: %v", err)
+	var (
+		m   *invasion.Map
+		inv invasion.Invasion
+	)
+	if len(*resume) > 0 {
+		for _, name := range []string{"fight", "fight-threshold", "movement", "alien-movement", "history", "factions"} {
+			if isSet(name) {
+				log.Fatalf("-%s is restored from the checkpoint and can't be changed", name)
+			}
+		}
+		inv = readCheckpoint(*resume)
+		m = inv.Map()
+	} else {
+		m = readMap(flag.Arg(0), mapFormat)
+		inv = newInvasion(m, os.Stdout)
+	}
+	for _, cond := range stopConditions() {
+		inv.AddStopCondition(cond)
+	}
+	checkpointer, ok := inv.(interface{ Checkpoint(io.Writer) error })
+	if len(*checkpoint) > 0 && !ok {
+		log.Fatalf("engine %s doesn't support checkpoints", *engine)
+	}
+	var eventsLog *os.File
+	var eventsBuf *bufio.Writer
+	if len(*events) > 0 {
+		eventsLog, err = os.OpenFile(*events, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer eventsLog.Close()
+		eventsBuf = bufio.NewWriter(eventsLog)
+		inv.AddSink(invasion.NewJSONLSink(eventsBuf))
 	}
+	// on interrupt simulation stops after the current step, and all outputs are written as usual
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		select {
+		case <-interrupts:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 
-	invasion := invasion.NewSerialInvasion(
-		m, rand.New(rand.NewSource(*seed)),
-		os.Stdout, *aliens, *moves,
-	)
-	invasion.Run()
+	steps := -1
+	if len(*checkpoint) > 0 {
+		steps = *checkpointEvery
+	}
+	for !inv.Done() && ctx.Err() == nil {
+		rst, err := inv.RunSteps(ctx, steps)
+		if err != nil {
+			log.Fatalf("simulation failed: %v", err)
+		}
+		if rst.Reason == invasion.StopConditionMet {
+			log.Printf("simulation stopped after %d steps: %v", inv.Stats().Steps, rst.Condition)
+		}
+		if rst.Reason == invasion.StopCancelled {
+			// second interrupt terminates the program immediately
+			signal.Reset(os.Interrupt)
+			log.Printf("simulation interrupted after %d steps", inv.Stats().Steps)
+		}
+		if len(*checkpoint) > 0 {
+			// events are flushed first, so that the log is never behind the checkpoint
+			if eventsBuf != nil {
+				if err := eventsBuf.Flush(); err != nil {
+					log.Fatalf("failed to flush events: %v", err)
+				}
+			}
+			// previous checkpoint is replaced only when the new one is completely written
+			tmp := *checkpoint + ".tmp"
+			if err := writeFile(tmp, checkpointer.Checkpoint); err != nil {
+				log.Fatalf("failed to write checkpoint: %v", err)
+			}
+			if err := os.Rename(tmp, *checkpoint); err != nil {
+				log.Fatalf("failed to replace checkpoint: %v", err)
+			}
+		}
+	}
+	if eventsLog != nil {
+		if err := eventsBuf.Flush(); err != nil {
+			log.Fatalf("failed to flush events: %v", err)
+		}
+		if err := eventsLog.Sync(); err != nil {
+			log.Fatalf("failed to fsync events: %v", err)
+		}
+	}
+
+	if len(*dot) > 0 {
+		if err := writeFile(*dot, inv.WriteDOT); err != nil {
+			log.Fatalf("failed to write DOT: %v", err)
+		}
+	}
+	if len(*svg) > 0 {
+		if err := writeFile(*svg, inv.WriteSVG); err != nil {
+			log.Fatalf("failed to write SVG: %v", err)
+		}
+	}
+	if report := invasion.NewFactionReport(inv.AllAliens(), inv.Ruins()); len(report) > 0 {
+		fmt.Fprint(os.Stderr, report)
+	}
+	if len(*historyOut) > 0 {
+		if err := writeFile(*historyOut, func(w io.Writer) error {
+			return writeHistory(w, inv.AllAliens())
+		}); err != nil {
+			log.Fatalf("failed to write history: %v", err)
+		}
+	}
 
 	// TODO deduplicate this code and code in invasion cmd
 	if len(*out) > 0 {
@@ -83,8 +230,7 @@ func main() {
 		}
 		defer f.Close()
 		buf := bufio.NewWriter(f) // 4mb will be allocated by default
-		_, err = m.WriteTo(buf)
-		if err != nil {
+		if err := m.Encode(buf, mapFormat); err != nil {
 			log.Fatalf("failed to write map: %v", err)
 		}
 		if err := buf.Flush(); err != nil {
@@ -94,9 +240,222 @@ func main() {
 			log.Fatalf("failed to fsync: %v", err)
 		}
 	} else {
-		_, err := m.WriteTo(os.Stdout)
-		if err != nil {
+		if err := m.Encode(os.Stdout, mapFormat); err != nil {
 			log.Fatalf("failed to print to stdout: %v", err)
 		}
 	}
 }
+
+// writeFile truncates file and writes to it using buffered writer.
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	buf := bufio.NewWriter(f)
+	if err := write(buf); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// writeHistory writes history of every alien as a separate json object.
+func writeHistory(w io.Writer, aliens []*invasion.Alien) error {
+	enc := json.NewEncoder(w)
+	for _, a := range aliens {
+		if err := enc.Encode(struct {
+			ID       int               `json:"id"`
+			Dead     bool              `json:"dead,omitempty"`
+			Location string            `json:"location,omitempty"`
+			History  *invasion.History `json:"history"`
+		}{a.ID, a.Dead, a.Location, a.History}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readMap reads map from a file.
+func readMap(path string, format invasion.Format) *invasion.Map {
+	f, err := os.OpenFile(path, os.O_RDONLY, 0600)
+	if err != nil {
+		log.Fatalf("failed to open a file %s: %v", path, err)
+	}
+	defer f.Close()
+
+	m := invasion.NewMap()
+	if err := m.Decode(bufio.NewReader(f), format); err != nil {
+		log.Fatalf("failed to fill the map: %v", err)
+	}
+	return m
+}
+
+// newInvasion creates invasion using parameters from the flags.
+func newInvasion(m *invasion.Map, notifier io.Writer) invasion.Invasion {
+	e, err := invasion.ParseEngine(*engine)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	resolver, err := invasion.ParseFightResolver(*fight)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	opts := []invasion.Option{
+		invasion.WithEngine(e),
+		invasion.WithSeed(uint64(*seed)),
+		invasion.WithAliens(*aliens),
+		invasion.WithMoves(*moves),
+		invasion.WithWorkers(*workers),
+		invasion.WithStepBudget(*budget),
+		invasion.WithFightThreshold(*fightThreshold),
+		invasion.WithFightResolver(resolver),
+		invasion.WithHistory(*history),
+		invasion.WithNotifier(notifier),
+	}
+	n := *aliens
+	if len(*factions) > 0 {
+		fs, err := invasion.ParseFactions(*factions)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		opts = append(opts, invasion.WithFactions(fs...))
+		n = 0
+		for _, f := range fs {
+			n += f.Aliens
+		}
+	}
+	opts = append(opts, movementOptions(n)...)
+	return invasion.NewInvasion(m, opts...)
+}
+
+// movementOptions creates options for movement strategies from the flags, n is a number of aliens.
+func movementOptions(n int) []invasion.Option {
+	s, err := invasion.ParseMovementStrategy(*movement)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	opts := []invasion.Option{invasion.WithMovement(s)}
+	if len(*alienMovement) == 0 {
+		return opts
+	}
+	for _, spec := range strings.Split(*alienMovement, ",") {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 {
+			log.Fatalf("alien movement %q must be in the format id=strategy", spec)
+		}
+		id, err := strconv.Atoi(parts[0])
+		if err != nil {
+			log.Fatalf("alien id in %q is not an integer", spec)
+		}
+		if id < 0 || id >= n {
+			log.Fatalf("alien %d in %q doesn't exist, ids are from 0 to %d", id, spec, n-1)
+		}
+		s, err := invasion.ParseMovementStrategy(parts[1])
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		opts = append(opts, invasion.WithAlienMovement(id, s))
+	}
+	return opts
+}
+
+// isSet returns true if the flag was provided on the command line.
+func isSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
+
+// stopConditions creates stop conditions from the flags.
+func stopConditions() []invasion.StopCondition {
+	var conds []invasion.StopCondition
+	if *stopDestroyed != 0 {
+		cond, err := invasion.StopWhenDestroyed(*stopDestroyed)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		conds = append(conds, cond)
+	}
+	if len(*stopCity) > 0 {
+		conds = append(conds, invasion.StopWhenCityFalls(*stopCity))
+	}
+	if *stopTrapped {
+		conds = append(conds, invasion.StopWhenOnlyTrapped())
+	}
+	if *stopIdle > 0 {
+		conds = append(conds, invasion.StopWhenIdle(*stopIdle))
+	}
+	return conds
+}
+
+// readCheckpoint resumes simulation from a checkpoint file.
+func readCheckpoint(path string) *invasion.SerialInvasion {
+	f, err := os.OpenFile(path, os.O_RDONLY, 0600)
+	if err != nil {
+		log.Fatalf("failed to open a file %s: %v", path, err)
+	}
+	defer f.Close()
+
+	inv, err := invasion.ResumeSerialInvasion(bufio.NewReader(f), os.Stdout)
+	if err != nil {
+		log.Fatalf("failed to resume: %v", err)
+	}
+	return inv
+}
+
+// render executes render subcommand.
+func render(args []string) {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, renderUsage)
+		fs.PrintDefaults()
+	}
+	as := fs.String("as", "ascii", "how to render the map, ascii, dot or svg")
+	steps := fs.Int("steps", 0, "number of simulation steps to execute before rendering, negative to execute whole simulation")
+	fs.IntVar(aliens, "n", *aliens, "number of aliens that invade the world")
+	fs.IntVar(moves, "m", *moves, "max number of moves every alien can make")
+	fs.Int64Var(seed, "seed", *seed, "provided seed will be used for simulation")
+	fs.StringVar(format, "format", *format, "format of the input map, text or json")
+	fs.StringVar(engine, "engine", *engine, "simulation engine, serial or parallel")
+	fs.IntVar(workers, "workers", *workers, "number of workers for the parallel engine, all CPUs by default")
+	_ = fs.Parse(args) // exits on error
+	if fs.NArg() < 1 {
+		log.Fatalf("render expects first positional argument to be a file")
+	}
+	mapFormat, err := invasion.ParseFormat(*format)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	m := readMap(fs.Arg(0), mapFormat)
+	inv := newInvasion(m, os.Stderr)
+	for i := 0; !inv.Done() && (*steps < 0 || i < *steps); i++ {
+		inv.Step()
+	}
+
+	var write func(io.Writer) error
+	switch *as {
+	case "ascii":
+		write = inv.WriteASCII
+	case "dot":
+		write = inv.WriteDOT
+	case "svg":
+		write = inv.WriteSVG
+	default:
+		log.Fatalf("unknown render format %q, expected ascii, dot or svg", *as)
+	}
+	buf := bufio.NewWriter(os.Stdout)
+	if err := write(buf); err != nil {
+		log.Fatalf("failed to render: %v", err)
+	}
+	if err := buf.Flush(); err != nil {
+		log.Fatalf("failed to flush buffer: %v", err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/dshulyak/invasion"
@@ -16,8 +17,9 @@ var (
 	cities = flag.Int("c", 100, "number of cities in the random map")
 	routes = flag.Int("r", 50, "number of unique routes in the random map")
 	// TODO replace with positional
-	out  = flag.String("out", "", "if provided, map will be saved to a file, otherwise printed to stdout. file will be truncated.")
-	seed = flag.Int64("seed", time.Now().UnixNano(), "if non zero seed will be used for map generation")
+	out    = flag.String("out", "", "if provided, map will be saved to a file, otherwise printed to stdout. file will be truncated.")
+	seed   = flag.Int64("seed", time.Now().UnixNano(), "if non zero seed will be used for map generation")
+	format = flag.String("format", "text", "format of the generated map, text or json")
 
 	usage = `Generates map of the desired size and connectivity.
 
@@ -41,10 +43,26 @@ func main() {
 		flag.PrintDefaults()
 	}
 	flag.Parse()
+	mapFormat, err := invasion.ParseFormat(*format)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
 
 	log.Printf("using seed %d", *seed)
 
 	m := invasion.GenerateMap(rand.New(rand.NewSource(*seed)), *cities, *routes)
+	// stamp parameters into the headers so that the same map can be generated again
+	headers := []invasion.Header{
+		{Key: "generator", Value: "mapgen"},
+		{Key: "seed", Value: strconv.FormatInt(*seed, 10)},
+		{Key: "cities", Value: strconv.Itoa(*cities)},
+		{Key: "routes", Value: strconv.Itoa(*routes)},
+	}
+	for _, h := range headers {
+		if err := m.SetHeader(h.Key, h.Value); err != nil {
+			log.Fatalf("failed to set header: %v", err)
+		}
+	}
 
 	// TODO deduplicate this code and code in invasion cmd
 	if len(*out) > 0 {
@@ -54,8 +72,7 @@ func main() {
 		}
 		defer f.Close()
 		buf := bufio.NewWriter(f) // 4kb will be allocated by default
-		_, err = m.WriteTo(buf)
-		if err != nil {
+		if err := m.Encode(buf, mapFormat); err != nil {
 			log.Fatalf("failed to write map: %v", err)
 		}
 		if err := buf.Flush(); err != nil {
@@ -65,8 +82,7 @@ func main() {
 			log.Fatalf("failed to fsync: %v", err)
 		}
 	} else {
-		_, err := m.WriteTo(os.Stdout)
-		if err != nil {
+		if err := m.Encode(os.Stdout, mapFormat); err != nil {
 			log.Fatalf("failed to print to stdout: %v", err)
 		}
 	}
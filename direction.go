@@ -0,0 +1,67 @@
+package invasion
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Direction is one of the cardinal directions. Zero value is not a valid direction.
+type Direction uint8
+
+const (
+	// North direction.
+	North Direction = iota + 1
+	// South direction.
+	South
+	// East direction.
+	East
+	// West direction.
+	West
+)
+
+var directionNames = [...]string{
+	North: "north",
+	South: "south",
+	East:  "east",
+	West:  "west",
+}
+
+// ParseDirection parses case insensitive name of the direction.
+// Returns error that wraps ErrUnknownDirection if name is not a cardinal direction.
+func ParseDirection(name string) (Direction, error) {
+	lower := strings.ToLower(name)
+	for d := North; d <= West; d++ {
+		if directionNames[d] == lower {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrUnknownDirection, name)
+}
+
+// Valid returns true if direction is one of the cardinal directions.
+func (d Direction) Valid() bool {
+	return d >= North && d <= West
+}
+
+// Reverse returns opposite direction, e.g. south for north. Reverse of invalid direction is invalid.
+func (d Direction) Reverse() Direction {
+	switch d {
+	case North:
+		return South
+	case South:
+		return North
+	case East:
+		return West
+	case West:
+		return East
+	default:
+		return 0
+	}
+}
+
+func (d Direction) String() string {
+	if !d.Valid() {
+		return fmt.Sprintf("Direction(%d)", d)
+	}
+	return directionNames[d]
+}
@@ -0,0 +1,85 @@
+package invasion
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var dotColors = map[cityState]string{
+	stateFree:      "white",
+	stateInvaded:   "orange",
+	stateTrapped:   "red",
+	stateDestroyed: "gray",
+}
+
+// WriteDOT writes map in the graphviz DOT format, e.g. to render it with `dot -Tsvg`.
+// Every route is written once with direction labels on both ends of the edge.
+func (m *Map) WriteDOT(w io.Writer) error {
+	return writeDOT(w, newScene(m, nil, nil))
+}
+
+// WriteDOT writes current state of the invasion in the graphviz DOT format.
+// Invaded cities are orange, cities with trapped aliens are red. Destroyed cities are gray
+// and routes that they had before destruction are dashed.
+func (si *simulation) WriteDOT(w io.Writer) error {
+	return writeDOT(w, newScene(si.m, si.ruins, si.located()))
+}
+
+func writeDOT(w io.Writer, s *scene) error {
+	buf := bufio.NewWriter(w)
+	fmt.Fprintln(buf, "graph invasion {")
+	fmt.Fprintln(buf, "  node [shape=box, style=filled];")
+	s.m.IterateCities(func(c *City, _ []Route) bool {
+		label := c.Name
+		if ids := s.aliens[c.ID]; len(ids) > 0 {
+			label = fmt.Sprintf("%s\n%s", label, aliensLabel(ids))
+		}
+		attrs := fmt.Sprintf("label=%s, fillcolor=%s", dotQuote(label), dotColors[s.states[c.ID]])
+		if s.states[c.ID] == stateDestroyed {
+			attrs += ", fontcolor=white"
+		}
+		fmt.Fprintf(buf, "  %s [%s];\n", dotQuote(c.ID), attrs)
+		return true
+	})
+	s.m.IterateCities(func(c *City, routes []Route) bool {
+		for _, r := range routes {
+			// every route is in the table twice, write only one of them
+			if c.ID > r.To {
+				continue
+			}
+			attrs := fmt.Sprintf("taillabel=%q, headlabel=%q", r.Direction, r.Direction.Reverse())
+			if s.destroyedRoute(c.ID, r) {
+				attrs += ", style=dashed, color=gray"
+			}
+			fmt.Fprintf(buf, "  %s -- %s [%s];\n", dotQuote(c.ID), dotQuote(r.To), attrs)
+		}
+		return true
+	})
+	fmt.Fprintln(buf, "}")
+	return buf.Flush()
+}
+
+func aliensLabel(ids []int) string {
+	return "aliens: " + aliensList(ids)
+}
+
+// dotQuote quotes id or label. Only quote and backslash needs to be escaped.
+func dotQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		case '\n':
+			b.WriteString(`\n`)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,333 @@
+package invasion
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Invasion is implemented by every simulation engine, so that the same scenario can be executed
+// with different engines. Simulation is configured with options passed to NewInvasion, sinks and stop
+// conditions can be added later as well, e.g. to the simulation resumed from the checkpoint.
+type Invasion interface {
+	// Step advances simulation and returns events for every state transition that happened in the step.
+	Step() []Event
+	// Run advances simulation until it is done or until context is cancelled, and passes every event to the sinks.
+	Run(ctx context.Context) (Result, error)
+	// RunSteps is the same as Run but executes at most n steps.
+	RunSteps(ctx context.Context, n int) (Result, error)
+	// Done returns true if simulation can't make progress.
+	Done() bool
+	// AddSink adds a sink that will receive every event emitted during Run.
+	AddSink(EventSink)
+	// AddStopCondition adds a condition that can terminate simulation.
+	AddStopCondition(StopCondition)
+	// Aliens returns aliens that didn't make all moves, dead aliens may be returned until they are garbage collected.
+	Aliens() []*Alien
+	// Alien returns the alien with the id, including dead aliens.
+	Alien(id int) *Alien
+	// AllAliens returns every alien including dead and aliens that made all moves, ordered by id.
+	AllAliens() []*Alien
+	// Map returns the map that is updated by the simulation.
+	Map() *Map
+	// Ruins returns cities destroyed during invasion in the order of destruction.
+	Ruins() []Ruin
+	// Stats returns counters that describe current state of the simulation.
+	Stats() Stats
+
+	// WriteDOT writes current state of the map in graphviz DOT format.
+	WriteDOT(io.Writer) error
+	// WriteSVG draws current state of the map as SVG image.
+	WriteSVG(io.Writer) error
+	// WriteASCII draws current state of the map as text for the terminal.
+	WriteASCII(io.Writer) error
+}
+
+// Stats are counters that describe state of the simulation.
+type Stats struct {
+	// Steps is a number of executed steps, rounds for ParallelInvasion.
+	Steps int
+	// Cities is a number of cities that are still on the map.
+	Cities    int
+	Destroyed int
+	// Aliens is a number of aliens that are alive, including trapped and exhausted aliens.
+	Aliens    int
+	Trapped   int
+	Exhausted int
+	Dead      int
+}
+
+// StopReason describes why Run returned.
+type StopReason uint8
+
+const (
+	// StopMapEmpty is used when all cities were destroyed.
+	StopMapEmpty StopReason = iota + 1
+	// StopAliensDone is used when all aliens are either dead or made all moves.
+	StopAliensDone
+	// StopCancelled is used when context was cancelled or its deadline exceeded.
+	StopCancelled
+	// StopBudget is used when simulation executed number of steps set by WithStepBudget.
+	StopBudget
+	// StopSteps is used when RunSteps executed requested number of steps.
+	StopSteps
+	// StopSinkFailed is used when a sink returned an error.
+	StopSinkFailed
+	// StopConditionMet is used when one of the stop conditions returned true.
+	StopConditionMet
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopMapEmpty:
+		return "map is empty"
+	case StopAliensDone:
+		return "aliens can't move"
+	case StopCancelled:
+		return "cancelled"
+	case StopBudget:
+		return "step budget exhausted"
+	case StopSteps:
+		return "steps executed"
+	case StopSinkFailed:
+		return "sink failed"
+	case StopConditionMet:
+		return "stop condition met"
+	}
+	return fmt.Sprintf("StopReason(%d)", r)
+}
+
+// Result describes how Run finished.
+type Result struct {
+	Reason StopReason
+	// Steps is a number of steps executed by Run.
+	Steps int
+	// Condition that stopped simulation if Reason is StopConditionMet.
+	Condition StopCondition
+}
+
+// Engine selects implementation of the Invasion.
+type Engine uint8
+
+const (
+	// EngineSerial is SerialInvasion.
+	EngineSerial Engine = iota + 1
+	// EngineParallel is ParallelInvasion.
+	EngineParallel
+)
+
+// ParseEngine parses name of the engine, either serial or parallel.
+func ParseEngine(name string) (Engine, error) {
+	switch name {
+	case "serial":
+		return EngineSerial, nil
+	case "parallel":
+		return EngineParallel, nil
+	}
+	return 0, fmt.Errorf("unknown engine %q, expected serial or parallel", name)
+}
+
+func (e Engine) String() string {
+	switch e {
+	case EngineSerial:
+		return "serial"
+	case EngineParallel:
+		return "parallel"
+	}
+	return fmt.Sprintf("Engine(%d)", e)
+}
+
+type config struct {
+	engine     Engine
+	seed       uint64
+	aliens     int
+	moves      int
+	workers    int
+	budget     int
+	threshold  int
+	resolver   FightResolver
+	movement   MovementStrategy
+	movements  map[int]MovementStrategy
+	history    int
+	factions   []Faction
+	notifier   io.Writer
+	sinks      []EventSink
+	conditions []StopCondition
+}
+
+// Option changes configuration of the invasion created by NewInvasion.
+type Option func(*config)
+
+// WithEngine selects engine, default is EngineSerial.
+func WithEngine(e Engine) Option {
+	return func(c *config) {
+		c.engine = e
+	}
+}
+
+// WithSeed sets seed for the random generator, default is 0.
+func WithSeed(seed uint64) Option {
+	return func(c *config) {
+		c.seed = seed
+	}
+}
+
+// WithAliens sets number of aliens, default is 100.
+func WithAliens(n int) Option {
+	return func(c *config) {
+		c.aliens = n
+	}
+}
+
+// WithMoves sets max number of moves for every alien, default is 10000.
+func WithMoves(n int) Option {
+	return func(c *config) {
+		c.moves = n
+	}
+}
+
+// WithWorkers sets number of workers for EngineParallel, by default all available CPUs are used.
+func WithWorkers(n int) Option {
+	return func(c *config) {
+		c.workers = n
+	}
+}
+
+// WithStepBudget sets max number of steps (rounds for EngineParallel), by default steps are not limited.
+func WithStepBudget(n int) Option {
+	return func(c *config) {
+		c.budget = n
+	}
+}
+
+// WithFightThreshold sets number of aliens in the same city that start a fight, default is DefaultFightThreshold.
+// Outcome of the fight is decided by the resolver set with WithFightResolver, and aliens of the same faction
+// don't fight at all. Threshold lower than 2 is ignored.
+func WithFightThreshold(k int) Option {
+	return func(c *config) {
+		if k >= 2 {
+			c.threshold = k
+		}
+	}
+}
+
+// WithFightResolver sets resolver for the fights, default is DestroyAll.
+func WithFightResolver(r FightResolver) Option {
+	return func(c *config) {
+		c.resolver = r
+	}
+}
+
+// WithMovement sets movement strategy for all aliens, default is Uniform.
+func WithMovement(s MovementStrategy) Option {
+	return func(c *config) {
+		c.movement = s
+	}
+}
+
+// WithAlienMovement sets movement strategy for the alien with the id, can be used multiple times.
+func WithAlienMovement(id int, s MovementStrategy) Option {
+	return func(c *config) {
+		c.movements[id] = s
+	}
+}
+
+// WithHistory records visited cities for every alien, at most limit latest visits are kept.
+// Zero limit keeps all visits, negative limit doesn't enable history. By default history is recorded
+// only for aliens that use AvoidVisited.
+func WithHistory(limit int) Option {
+	return func(c *config) {
+		c.history = limit
+	}
+}
+
+// WithFactions spawns aliens for every faction, number of aliens set by WithAliens is ignored.
+// Aliens get ids in the order of factions, e.g. for red:2,blue:2 red aliens are 0 and 1, blue aliens are 2 and 3.
+func WithFactions(factions ...Faction) Option {
+	return func(c *config) {
+		c.factions = factions
+	}
+}
+
+// WithNotifier sets writer for important events, by default they are discarded.
+func WithNotifier(w io.Writer) Option {
+	return func(c *config) {
+		c.notifier = w
+	}
+}
+
+// WithSink adds a sink that will receive every event emitted during Run.
+func WithSink(sink EventSink) Option {
+	return func(c *config) {
+		c.sinks = append(c.sinks, sink)
+	}
+}
+
+// WithStopCondition adds a condition that can terminate simulation, can be used multiple times.
+// Simulation stops when any of the conditions is met.
+func WithStopCondition(cond StopCondition) Option {
+	return func(c *config) {
+		c.conditions = append(c.conditions, cond)
+	}
+}
+
+// NewInvasion creates invasion for the map using the engine and parameters from the options.
+// Serial engine uses NewPCG(seed, 0) as the random generator.
+func NewInvasion(m *Map, opts ...Option) Invasion {
+	c := config{
+		engine:    EngineSerial,
+		aliens:    100,
+		moves:     10000,
+		threshold: DefaultFightThreshold,
+		resolver:  DestroyAll(),
+		movement:  Uniform(),
+		movements: map[int]MovementStrategy{},
+		history:   -1,
+		notifier:  ioutil.Discard,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if len(c.factions) > 0 {
+		c.aliens = 0
+		for _, f := range c.factions {
+			c.aliens += f.Aliens
+		}
+	}
+	var (
+		inv Invasion
+		sim *simulation
+	)
+	switch c.engine {
+	case EngineParallel:
+		pi := NewParallelInvasion(m, c.seed, c.notifier, c.aliens, c.moves, c.workers)
+		inv, sim = pi, &pi.simulation
+	default:
+		si := NewSerialInvasion(m, NewPCG(c.seed, 0), c.notifier, c.aliens, c.moves)
+		inv, sim = si, &si.simulation
+	}
+	id := 0
+	for _, f := range c.factions {
+		for i := 0; i < f.Aliens; i++ {
+			sim.aliens[id].Faction = f.Name
+			id++
+		}
+	}
+	sim.budget = c.budget
+	sim.threshold = c.threshold
+	sim.resolver = c.resolver
+	// history is enabled first, so that strategies that need it don't override the limit
+	sim.enableHistory(c.history)
+	sim.setMovementStrategy(c.movement)
+	for id, s := range c.movements {
+		sim.setAlienMovementStrategy(id, s)
+	}
+	for _, sink := range c.sinks {
+		inv.AddSink(sink)
+	}
+	for _, cond := range c.conditions {
+		inv.AddStopCondition(cond)
+	}
+	return inv
+}
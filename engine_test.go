@@ -0,0 +1,122 @@
+package invasion
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvasionEngines(t *testing.T) {
+	data := mapText(t, GenerateMap(rand.New(rand.NewSource(13)), 400, 600))
+	for _, engine := range []Engine{EngineSerial, EngineParallel} {
+		collected := &collectSink{}
+		m := NewMapFromString(data)
+		inv := NewInvasion(m,
+			WithEngine(engine),
+			WithSeed(13),
+			WithAliens(150),
+			WithMoves(40),
+			WithWorkers(2),
+			WithSink(collected),
+		)
+		_, err := inv.Run(context.Background())
+		require.NoError(t, err, "engine %v", engine)
+		require.True(t, inv.Done())
+		require.NoError(t, VerifyInvariants(m, inv.Aliens()))
+
+		stats := inv.Stats()
+		require.Equal(t, 150, stats.Aliens+stats.Dead, "engine %v", engine)
+		require.Equal(t, stats.Dead, 2*stats.Destroyed, "engine %v", engine)
+		require.Equal(t, 400, stats.Cities+stats.Destroyed, "engine %v", engine)
+		require.Len(t, inv.Ruins(), stats.Destroyed)
+		destroyed := 0
+		for _, ev := range collected.evs {
+			if ev.Type == CityDestroyed {
+				destroyed++
+			}
+		}
+		require.Equal(t, stats.Destroyed, destroyed, "engine %v", engine)
+	}
+}
+
+func TestInvasionFightThreshold(t *testing.T) {
+	data := mapText(t, GenerateMap(rand.New(rand.NewSource(16)), 50, 60))
+	for _, engine := range []Engine{EngineSerial, EngineParallel} {
+		name := fmt.Sprintf("engine %v", engine)
+		fights := 0
+		inv := requireConsistent(t, name, data, 30, 3, func(inv Invasion, evs []Event) {
+			for _, ev := range evs {
+				if ev.Type == AliensFought {
+					require.Len(t, ev.Aliens, 3, name)
+					fights++
+				}
+			}
+			inv.Map().IterateCities(func(c *City, _ []Route) bool {
+				require.Less(t, len(c.Occupants), 3, name)
+				return true
+			})
+		}, WithEngine(engine), WithSeed(16), WithAliens(100))
+		require.NotZero(t, fights, name)
+		require.Equal(t, 3*inv.Stats().Destroyed, inv.Stats().Dead, name)
+	}
+}
+
+func TestInvasionRunCancelled(t *testing.T) {
+	inv := NewInvasion(GenerateMap(rand.New(rand.NewSource(14)), 100, 100), WithAliens(10))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rst, err := inv.Run(ctx)
+	require.NoError(t, err)
+	require.Equal(t, Result{Reason: StopCancelled}, rst)
+	require.Zero(t, inv.Stats().Steps)
+}
+
+func TestInvasionRunStopReasons(t *testing.T) {
+	data := mapText(t, GenerateMap(rand.New(rand.NewSource(15)), 100, 150))
+	for _, engine := range []Engine{EngineSerial, EngineParallel} {
+		inv := NewInvasion(NewMapFromString(data), WithEngine(engine), WithAliens(10), WithMoves(100), WithStepBudget(5))
+		rst, err := inv.RunSteps(context.Background(), 3)
+		require.NoError(t, err)
+		require.Equal(t, Result{Reason: StopSteps, Steps: 3}, rst, "engine %v", engine)
+
+		rst, err = inv.Run(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, Result{Reason: StopBudget, Steps: 2}, rst, "engine %v", engine)
+		require.True(t, inv.Done())
+
+		inv = NewInvasion(NewMapFromString(data), WithEngine(engine), WithAliens(10), WithMoves(10))
+		rst, err = inv.Run(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, StopAliensDone, rst.Reason, "engine %v", engine)
+		require.Equal(t, inv.Stats().Steps, rst.Steps, "engine %v", engine)
+
+		inv = NewInvasion(NewMapFromString("Foo\n"), WithEngine(engine), WithAliens(2))
+		rst, err = inv.Run(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, StopMapEmpty, rst.Reason, "engine %v", engine)
+	}
+}
+
+func TestDeprecatedAliases(t *testing.T) {
+	inv := NewSerialInvasion(NewMapFromString("Foo north=Bar\n"), NewPCG(1, 0), ioutil.Discard, 2, 2)
+	require.True(t, inv.Valid())
+	require.NotEmpty(t, inv.Next())
+	for inv.Valid() {
+		inv.Next()
+	}
+	require.True(t, inv.Done())
+}
+
+func TestParseEngine(t *testing.T) {
+	for _, e := range []Engine{EngineSerial, EngineParallel} {
+		parsed, err := ParseEngine(e.String())
+		require.NoError(t, err)
+		require.Equal(t, e, parsed)
+	}
+	_, err := ParseEngine("quantum")
+	require.Error(t, err)
+}
@@ -2,20 +2,146 @@ package invasion
 
 import "fmt"
 
-// NewEvent creates regular non-important event.
-func NewEvent(format string, a ...interface{}) Event {
-	return Event{Data: fmt.Sprintf(format, a...)}
+// EventType is a type of the state transition.
+type EventType uint8
+
+const (
+	// AlienSpawned is emitted when alien invades the first city.
+	AlienSpawned EventType = iota + 1
+	// AlienMoved is emitted when alien leaves a city and invades another city.
+	AlienMoved
+	// AlienTrapped is emitted when alien can't leave a city because there are no routes from it.
+	AlienTrapped
+	// AlienExhausted is emitted when alien made all moves.
+	AlienExhausted
+	// AliensFought is emitted when number of aliens in the city reaches fight threshold, unless all of them are
+	// of the same faction. Aliens die in the fight, unless they are reported by AlienSurvived.
+	AliensFought
+	// CityDestroyed is emitted when city is destroyed in the fight. Important event.
+	CityDestroyed
+	// AlienSurvived is emitted after AliensFought for every alien that survived the fight, if the city wasn't destroyed.
+	AlienSurvived
+	// CityDamaged is emitted after AliensFought, instead of CityDestroyed, if the city wasn't destroyed.
+	CityDamaged
+)
+
+var eventTypeNames = [...]string{
+	AlienSpawned:   "spawned",
+	AlienMoved:     "moved",
+	AlienTrapped:   "trapped",
+	AlienExhausted: "exhausted",
+	AliensFought:   "fought",
+	CityDestroyed:  "destroyed",
+	AlienSurvived:  "survived",
+	CityDamaged:    "damaged",
+}
+
+func (t EventType) String() string {
+	if t < AlienSpawned || t > CityDamaged {
+		return fmt.Sprintf("EventType(%d)", t)
+	}
+	return eventTypeNames[t]
 }
 
-// NewImportantEvent creates important events.
-func NewImportantEvent(format string, a ...interface{}) Event {
-	ev := NewEvent(format, a...)
-	ev.Important = true
-	return ev
+// MarshalText implements encoding.TextMarshaler.
+func (t EventType) MarshalText() ([]byte, error) {
+	if t < AlienSpawned || t > CityDamaged {
+		return nil, fmt.Errorf("unknown event type %d", t)
+	}
+	return []byte(t.String()), nil
 }
 
-// Event can be used to track progress of the invasion.
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (t *EventType) UnmarshalText(text []byte) error {
+	for et := AlienSpawned; et <= CityDamaged; et++ {
+		if eventTypeNames[et] == string(text) {
+			*t = et
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown event type %q", text)
+}
+
+// Event describes a single state transition of the simulation. Events that are emitted
+// in the same step are always in the order of transitions, e.g. AlienMoved, AliensFought, CityDestroyed
+// or AlienMoved, AliensFought, AlienSurvived, CityDamaged.
 type Event struct {
-	Important bool
-	Data      string
+	Type EventType `json:"type"`
+	// Step of the simulation when event happened, starts from 1.
+	Step int `json:"step"`
+	// Aliens involved in the transition. For AliensFought, CityDestroyed and CityDamaged first alien is the one
+	// that invaded the city, others are occupants of the city in the order of arrival.
+	Aliens []int `json:"aliens"`
+	// Factions of the aliens, in the same order as Aliens. Empty if none of the aliens has a faction.
+	Factions []string `json:"factions,omitempty"`
+	// Moves made by the first alien, including the move made in this step.
+	Moves int `json:"moves"`
+	// City is an id of the city where transition happened, e.g. where alien moved or city that was destroyed.
+	City string `json:"city,omitempty"`
+	// CityName is a name of the City.
+	CityName string `json:"city_name,omitempty"`
+	// From is an id of the city that alien left. Only for AlienMoved.
+	From string `json:"from,omitempty"`
+	// Direction of the route from From to City. Only for AlienMoved.
+	Direction Direction `json:"direction,omitempty"`
+}
+
+// Important returns true for events that should be reported to the user.
+func (ev Event) Important() bool {
+	return ev.Type == CityDestroyed
+}
+
+// String returns human readable description of the event.
+func (ev Event) String() string {
+	switch ev.Type {
+	case AlienSpawned:
+		return fmt.Sprintf("%s landed in %s", ev.alien(0), ev.CityName)
+	case AlienMoved:
+		return fmt.Sprintf("%s moved %v from %s to %s", ev.alien(0), ev.Direction, ev.From, ev.City)
+	case AlienTrapped:
+		return fmt.Sprintf("%s is trapped in %s", ev.alien(0), ev.CityName)
+	case AlienExhausted:
+		return fmt.Sprintf("%s made all moves and stays in %s", ev.alien(0), ev.CityName)
+	case AliensFought:
+		return fmt.Sprintf("%s fought in %s", ev.aliensSentence(), ev.CityName)
+	case CityDestroyed:
+		return fmt.Sprintf("%s has been destroyed by %s!", ev.CityName, ev.aliensSentence())
+	case AlienSurvived:
+		return fmt.Sprintf("%s survived the fight in %s", ev.alien(0), ev.CityName)
+	case CityDamaged:
+		return fmt.Sprintf("%s has been damaged by %s", ev.CityName, ev.aliensSentence())
+	}
+	return fmt.Sprintf("%v at step %d", ev.Type, ev.Step)
+}
+
+// faction returns faction of the i-th alien, empty if alien doesn't have a faction.
+func (ev Event) faction(i int) string {
+	if i < len(ev.Factions) {
+		return ev.Factions[i]
+	}
+	return ""
+}
+
+// alien formats i-th alien as "alien 1" or "alien 1 (red)" if alien has a faction.
+func (ev Event) alien(i int) string {
+	if faction := ev.faction(i); len(faction) > 0 {
+		return fmt.Sprintf("alien %d (%s)", ev.Aliens[i], faction)
+	}
+	return fmt.Sprintf("alien %d", ev.Aliens[i])
+}
+
+// aliensSentence formats aliens as "alien 1 and alien 2".
+func (ev Event) aliensSentence() string {
+	rst := ""
+	for i := range ev.Aliens {
+		switch {
+		case i == 0:
+		case i == len(ev.Aliens)-1:
+			rst += " and "
+		default:
+			rst += ", "
+		}
+		rst += ev.alien(i)
+	}
+	return rst
 }
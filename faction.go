@@ -0,0 +1,148 @@
+package invasion
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Faction is a species of aliens. Aliens of the same faction share cities peacefully.
+type Faction struct {
+	Name string
+	// Aliens is a number of aliens that spawn for the faction.
+	Aliens int
+}
+
+// ParseFactions parses factions in the format name:aliens separated by commas, e.g. red:50,blue:50.
+func ParseFactions(spec string) ([]Faction, error) {
+	var factions []Faction
+	seen := map[string]bool{}
+	for _, part := range strings.Split(spec, ",") {
+		fields := strings.Split(part, ":")
+		if len(fields) != 2 || len(fields[0]) == 0 {
+			return nil, fmt.Errorf("faction %q must be in the format name:aliens", part)
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("number of aliens in %q must be a non-negative integer", part)
+		}
+		if seen[fields[0]] {
+			return nil, fmt.Errorf("faction %s is defined twice", fields[0])
+		}
+		seen[fields[0]] = true
+		factions = append(factions, Faction{Name: fields[0], Aliens: n})
+	}
+	return factions, nil
+}
+
+// peaceful returns true if all occupants are of the same faction. Aliens without faction
+// are hostile to everyone.
+func peaceful(occupants []int, aliens map[int]*Alien) bool {
+	faction := aliens[occupants[0]].Faction
+	if len(faction) == 0 {
+		return false
+	}
+	for _, id := range occupants[1:] {
+		if aliens[id].Faction != faction {
+			return false
+		}
+	}
+	return true
+}
+
+// FactionStats is a result of the invasion for the faction.
+type FactionStats struct {
+	Name   string
+	Aliens int
+	Alive  int
+	Dead   int
+	// Destroyed are names of the cities that were destroyed in the fights where faction took part,
+	// in the order of destruction.
+	Destroyed []string
+}
+
+// FactionReport is a result of the invasion for every faction, ordered by faction name.
+type FactionReport []FactionStats
+
+// NewFactionReport creates report from all aliens, including dead, and cities destroyed during invasion.
+// Aliens without faction are not reported.
+func NewFactionReport(aliens []*Alien, ruins []Ruin) FactionReport {
+	byName := map[string]*FactionStats{}
+	factionOf := map[int]string{}
+	for _, a := range aliens {
+		if len(a.Faction) == 0 {
+			continue
+		}
+		factionOf[a.ID] = a.Faction
+		stats, exist := byName[a.Faction]
+		if !exist {
+			stats = &FactionStats{Name: a.Faction}
+			byName[a.Faction] = stats
+		}
+		stats.Aliens++
+		if a.Dead {
+			stats.Dead++
+		} else {
+			stats.Alive++
+		}
+	}
+	for _, ruin := range ruins {
+		involved := map[string]bool{}
+		for _, id := range ruin.City.Occupants {
+			if name, exist := factionOf[id]; exist && !involved[name] {
+				involved[name] = true
+				byName[name].Destroyed = append(byName[name].Destroyed, ruin.City.Name)
+			}
+		}
+	}
+	report := make(FactionReport, 0, len(byName))
+	for _, stats := range byName {
+		report = append(report, *stats)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].Name < report[j].Name
+	})
+	return report
+}
+
+// Winner returns the faction with the most alive aliens. Returns false if no faction has alive aliens
+// or several factions have the same number of them.
+func (r FactionReport) Winner() (string, bool) {
+	var (
+		winner *FactionStats
+		tie    bool
+	)
+	for i := range r {
+		stats := &r[i]
+		switch {
+		case stats.Alive == 0:
+		case winner == nil || stats.Alive > winner.Alive:
+			winner, tie = stats, false
+		case stats.Alive == winner.Alive:
+			tie = true
+		}
+	}
+	if winner == nil || tie {
+		return "", false
+	}
+	return winner.Name, true
+}
+
+// String returns human readable report, one line per faction and the winner.
+func (r FactionReport) String() string {
+	var b strings.Builder
+	for _, stats := range r {
+		fmt.Fprintf(&b, "%s: %d of %d aliens alive", stats.Name, stats.Alive, stats.Aliens)
+		if len(stats.Destroyed) > 0 {
+			fmt.Fprintf(&b, ", destroyed %s", strings.Join(stats.Destroyed, ", "))
+		}
+		b.WriteString("\n")
+	}
+	if winner, exist := r.Winner(); exist {
+		fmt.Fprintf(&b, "%s won!\n", winner)
+	} else {
+		b.WriteString("nobody won\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,87 @@
+package invasion
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFactions(t *testing.T) {
+	data := mapText(t, GenerateMap(rand.New(rand.NewSource(25)), 100, 150))
+	for _, engine := range []Engine{EngineSerial, EngineParallel} {
+		name := fmt.Sprintf("engine %v", engine)
+		shared := false
+		inv := requireConsistent(t, name, data, 50, DefaultFightThreshold, func(inv Invasion, evs []Event) {
+			for _, ev := range evs {
+				if ev.Type == AliensFought {
+					require.Len(t, ev.Factions, len(ev.Aliens), name)
+					require.Contains(t, ev.Factions, "red", name)
+					require.Contains(t, ev.Factions, "blue", name)
+				}
+			}
+			inv.Map().IterateCities(func(c *City, _ []Route) bool {
+				shared = shared || len(c.Occupants) > 1
+				return true
+			})
+		}, WithEngine(engine), WithSeed(25), WithFactions(Faction{Name: "red", Aliens: 40}, Faction{Name: "blue", Aliens: 40}))
+		require.True(t, shared, "aliens of the same faction never shared a city, engine %v", engine)
+
+		report := NewFactionReport(inv.AllAliens(), inv.Ruins())
+		require.Len(t, report, 2)
+		require.Equal(t, "blue", report[0].Name)
+		destroyed := 0
+		for _, stats := range report {
+			require.Equal(t, 40, stats.Aliens)
+			require.Equal(t, stats.Aliens, stats.Alive+stats.Dead)
+			destroyed += len(stats.Destroyed)
+		}
+		// every city is destroyed by both factions
+		require.Equal(t, 2*inv.Stats().Destroyed, destroyed, name)
+	}
+}
+
+func TestFactionsCheckpoint(t *testing.T) {
+	// aliens of the same faction share the only city peacefully
+	inv := NewInvasion(NewMapFromString("Foo\n"), WithMoves(10), WithFactions(Faction{Name: "red", Aliens: 3}))
+	_, err := inv.RunSteps(context.Background(), 2)
+	require.NoError(t, err)
+	resumed := resume(t, inv)
+	require.Zero(t, countEvents(runCollect(t, resumed), AliensFought))
+	require.Len(t, resumed.Map().GetCity("foo").Occupants, 3)
+	for _, a := range resumed.AllAliens() {
+		require.Equal(t, "red", a.Faction)
+	}
+}
+
+func TestFactionReportWinner(t *testing.T) {
+	report := FactionReport{{Name: "blue", Alive: 3}, {Name: "green", Alive: 0}, {Name: "red", Alive: 5}}
+	winner, exist := report.Winner()
+	require.True(t, exist)
+	require.Equal(t, "red", winner)
+	require.Contains(t, report.String(), "red won!")
+
+	report[0].Alive = 5
+	_, exist = report.Winner()
+	require.False(t, exist)
+
+	_, exist = FactionReport{{Name: "blue"}}.Winner()
+	require.False(t, exist)
+}
+
+func TestFactionEventString(t *testing.T) {
+	ev := Event{Type: CityDestroyed, Aliens: []int{1, 2}, Factions: []string{"red", "blue"}, CityName: "Foo"}
+	require.Equal(t, "Foo has been destroyed by alien 1 (red) and alien 2 (blue)!", ev.String())
+}
+
+func TestParseFactions(t *testing.T) {
+	factions, err := ParseFactions("red:50,blue:25")
+	require.NoError(t, err)
+	require.Equal(t, []Faction{{Name: "red", Aliens: 50}, {Name: "blue", Aliens: 25}}, factions)
+	for _, spec := range []string{"", "red", "red:x", ":5", "red:-1", "red:1,red:2"} {
+		_, err := ParseFactions(spec)
+		require.Error(t, err, spec)
+	}
+}
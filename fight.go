@@ -0,0 +1,162 @@
+package invasion
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Fight is a fight between aliens in the city that reached fight threshold.
+type Fight struct {
+	City *City
+	// Aliens that fight, first alien is the one that arrived, others are in the order of arrival.
+	Aliens []*Alien
+	// RNG must be used by resolvers that need randomness, so that simulation stays reproducible.
+	RNG RNG
+}
+
+// Outcome of the fight.
+type Outcome struct {
+	// Survivors stay in the city, other aliens die in the fight. Ignored if the city is destroyed.
+	Survivors []*Alien
+	// Destroyed is true if the city is destroyed, all aliens die together with the city.
+	Destroyed bool
+}
+
+// FightResolver decides outcome of the fight. Resolver must not modify the city or the aliens,
+// except for Strength of the survivors. Only resolvers created by ParseFightResolver can be checkpointed.
+type FightResolver interface {
+	Resolve(f Fight) Outcome
+}
+
+// DestroyAll is the default resolver, all aliens die and the city is destroyed.
+func DestroyAll() FightResolver {
+	return destroyAll{}
+}
+
+type destroyAll struct{}
+
+func (destroyAll) Resolve(Fight) Outcome {
+	return Outcome{Destroyed: true}
+}
+
+func (destroyAll) String() string {
+	return "destroy"
+}
+
+// RandomSurvivor picks one random alien that survives the fight, the city is not destroyed.
+func RandomSurvivor() FightResolver {
+	return randomSurvivor{}
+}
+
+type randomSurvivor struct{}
+
+func (randomSurvivor) Resolve(f Fight) Outcome {
+	return Outcome{Survivors: []*Alien{f.Aliens[f.RNG.Intn(len(f.Aliens))]}}
+}
+
+func (randomSurvivor) String() string {
+	return "survivor"
+}
+
+// StrongestSurvives picks the alien with the highest strength, alien that made more moves wins if
+// strength is equal. Winner takes strength of the defeated aliens and the city is not destroyed.
+// If there is no single winner all aliens die and the city is destroyed.
+func StrongestSurvives() FightResolver {
+	return strongestSurvives{}
+}
+
+type strongestSurvives struct{}
+
+func (strongestSurvives) Resolve(f Fight) Outcome {
+	var (
+		winner *Alien
+		tie    bool
+		total  int
+	)
+	for _, a := range f.Aliens {
+		total += a.Strength
+		switch {
+		case winner == nil || a.Strength > winner.Strength ||
+			(a.Strength == winner.Strength && a.Moves > winner.Moves):
+			winner, tie = a, false
+		case a.Strength == winner.Strength && a.Moves == winner.Moves:
+			tie = true
+		}
+	}
+	if tie {
+		return Outcome{Destroyed: true}
+	}
+	winner.Strength = total
+	return Outcome{Survivors: []*Alien{winner}}
+}
+
+func (strongestSurvives) String() string {
+	return "strongest"
+}
+
+// MergeAliens merges all aliens into the alien that arrived to the city first, strength of the merged
+// alien is a sum of the strengths. Other aliens are removed from simulation as dead, the city is not destroyed.
+func MergeAliens() FightResolver {
+	return mergeAliens{}
+}
+
+type mergeAliens struct{}
+
+func (mergeAliens) Resolve(f Fight) Outcome {
+	// first alien arrived last, second alien is the first occupant
+	merged := f.Aliens[0]
+	if len(f.Aliens) > 1 {
+		merged = f.Aliens[1]
+	}
+	total := 0
+	for _, a := range f.Aliens {
+		total += a.Strength
+	}
+	merged.Strength = total
+	return Outcome{Survivors: []*Alien{merged}}
+}
+
+func (mergeAliens) String() string {
+	return "merge"
+}
+
+// DamageCity kills all aliens in the fight, but the city is destroyed only in the n-th fight.
+// Fights that didn't destroy the city are counted in City.Damage.
+func DamageCity(n int) FightResolver {
+	return damageCity{fights: n}
+}
+
+type damageCity struct {
+	fights int
+}
+
+func (d damageCity) Resolve(f Fight) Outcome {
+	return Outcome{Destroyed: f.City.Damage+1 >= d.fights}
+}
+
+func (d damageCity) String() string {
+	return fmt.Sprintf("damage:%d", d.fights)
+}
+
+// ParseFightResolver parses name of the resolver: destroy, survivor, strongest, merge or damage:N.
+func ParseFightResolver(name string) (FightResolver, error) {
+	switch name {
+	case "destroy":
+		return DestroyAll(), nil
+	case "survivor":
+		return RandomSurvivor(), nil
+	case "strongest":
+		return StrongestSurvives(), nil
+	case "merge":
+		return MergeAliens(), nil
+	}
+	if strings.HasPrefix(name, "damage:") {
+		n, err := strconv.Atoi(strings.TrimPrefix(name, "damage:"))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("number of fights in %q must be a positive integer", name)
+		}
+		return DamageCity(n), nil
+	}
+	return nil, fmt.Errorf("unknown fight resolver %q, expected destroy, survivor, strongest, merge or damage:N", name)
+}
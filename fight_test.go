@@ -0,0 +1,91 @@
+package invasion
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFightResolvers(t *testing.T) {
+	data := mapText(t, GenerateMap(rand.New(rand.NewSource(18)), 100, 150))
+	for _, resolver := range []FightResolver{RandomSurvivor(), StrongestSurvives(), MergeAliens(), DamageCity(3)} {
+		for _, engine := range []Engine{EngineSerial, EngineParallel} {
+			name := fmt.Sprintf("%v engine %v", resolver, engine)
+			damaged := map[string]int{}
+			inv := requireConsistent(t, name, data, 50, DefaultFightThreshold, func(_ Invasion, evs []Event) {
+				for _, ev := range evs {
+					switch ev.Type {
+					case CityDamaged:
+						damaged[ev.City]++
+					case CityDestroyed:
+						if resolver == DamageCity(3) {
+							require.Equal(t, 2, damaged[ev.City], name)
+						}
+					}
+				}
+			}, WithEngine(engine), WithSeed(18), WithAliens(200), WithFightResolver(resolver))
+			require.NotEmpty(t, damaged, name)
+			stats := inv.Stats()
+			require.Equal(t, 200, stats.Aliens+stats.Dead, name)
+		}
+	}
+}
+
+func TestStrongestSurvives(t *testing.T) {
+	city := NewCity("Foo")
+	weak := &Alien{ID: 0, Strength: 1, Moves: 10}
+	strong := &Alien{ID: 1, Strength: 2, Moves: 1}
+	outcome := StrongestSurvives().Resolve(Fight{City: city, Aliens: []*Alien{weak, strong}})
+	require.Equal(t, Outcome{Survivors: []*Alien{strong}}, outcome)
+	require.Equal(t, 3, strong.Strength)
+
+	experienced := &Alien{ID: 2, Strength: 3, Moves: 2}
+	outcome = StrongestSurvives().Resolve(Fight{City: city, Aliens: []*Alien{strong, experienced}})
+	require.Equal(t, Outcome{Survivors: []*Alien{experienced}}, outcome)
+
+	tie := &Alien{ID: 3, Strength: 6, Moves: 2}
+	outcome = StrongestSurvives().Resolve(Fight{City: city, Aliens: []*Alien{experienced, tie}})
+	require.Equal(t, Outcome{Destroyed: true}, outcome)
+}
+
+func TestFightResolverCheckpoint(t *testing.T) {
+	// every alien lands in Foo, so every pair of aliens fights there. checkpoint is written after the first fight,
+	// and the city must be destroyed only in the third one
+	inv := NewInvasion(NewMapFromString("Foo\n"), WithAliens(6), WithMoves(10), WithFightResolver(DamageCity(3)))
+	for inv.Map().GetCity("foo").Damage == 0 {
+		inv.Step()
+	}
+	resumed := resume(t, inv)
+	evs := runCollect(t, resumed)
+	require.Equal(t, 2, countEvents(evs, AliensFought))
+	require.Equal(t, 1, countEvents(evs, CityDamaged))
+	require.Equal(t, 1, countEvents(evs, CityDestroyed))
+	require.Len(t, resumed.Ruins(), 1)
+	require.Equal(t, 2, resumed.Ruins()[0].City.Damage)
+
+	// merged alien keeps strength of both aliens
+	inv = NewInvasion(NewMapFromString("Foo\n"), WithAliens(3), WithMoves(10), WithFightResolver(MergeAliens()))
+	merged := -1
+	for merged < 0 {
+		for _, ev := range inv.Step() {
+			if ev.Type == AlienSurvived {
+				merged = ev.Aliens[0]
+			}
+		}
+	}
+	require.Equal(t, 2, resume(t, inv).Alien(merged).Strength)
+}
+
+func TestParseFightResolver(t *testing.T) {
+	for _, r := range []FightResolver{DestroyAll(), RandomSurvivor(), StrongestSurvives(), MergeAliens(), DamageCity(3)} {
+		parsed, err := ParseFightResolver(r.(interface{ String() string }).String())
+		require.NoError(t, err)
+		require.Equal(t, r, parsed)
+	}
+	for _, name := range []string{"peace", "damage:", "damage:0", "damage:x"} {
+		_, err := ParseFightResolver(name)
+		require.Error(t, err, name)
+	}
+}
@@ -0,0 +1,73 @@
+package invasion
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Format of the map encoding.
+type Format uint8
+
+const (
+	// FormatText is the line oriented format, see Map.ReadFrom and Map.WriteTo.
+	FormatText Format = iota + 1
+	// FormatJSON is the json format, see Map.MarshalJSON.
+	FormatJSON
+)
+
+// ParseFormat parses name of the format, either text or json.
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "text":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return 0, fmt.Errorf("unknown format %q, expected text or json", name)
+}
+
+func (f Format) String() string {
+	switch f {
+	case FormatText:
+		return "text"
+	case FormatJSON:
+		return "json"
+	}
+	return fmt.Sprintf("Format(%d)", f)
+}
+
+// Encode writes map to w using format f.
+func (m *Map) Encode(w io.Writer, f Format) error {
+	switch f {
+	case FormatText:
+		_, err := m.WriteTo(w)
+		return err
+	case FormatJSON:
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+		_, err = w.Write(data)
+		return err
+	}
+	return fmt.Errorf("unknown format %v", f)
+}
+
+// Decode reads map in format f from r.
+func (m *Map) Decode(r io.Reader, f Format) error {
+	switch f {
+	case FormatText:
+		_, err := m.ReadFrom(r)
+		return err
+	case FormatJSON:
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return m.UnmarshalJSON(data)
+	}
+	return fmt.Errorf("unknown format %v", f)
+}
@@ -22,16 +22,22 @@ func TestFuzzMapInvasion(t *testing.T) {
 	t.Logf("fuzz using seed %d", *fuzzSeed)
 
 	r := rand.New(rand.NewSource(*fuzzSeed))
-	m := GenerateMap(r, r.Intn(10000), r.Intn(10000))
+	data := mapText(t, GenerateMap(r, r.Intn(10000), r.Intn(10000)))
+	m := NewMapFromString(data)
 
-	inv := NewSerialInvasion(m, r, ioutil.Discard, r.Intn(100), r.Intn(10000))
+	aliens, moves := r.Intn(100), r.Intn(10000)
+	inv := NewSerialInvasion(m, r, ioutil.Discard, aliens, moves)
+	verifier := NewTransitionVerifier(NewMapFromString(data), moves, DefaultFightThreshold)
 
 	period := 100
-	for i := 0; inv.Valid(); i++ {
-		inv.Next()
+	for i := 0; !inv.Done(); i++ {
+		for _, ev := range inv.Step() {
+			require.NoError(t, verifier.Verify(ev))
+		}
 		if i%period == 0 {
 			require.NoError(t, VerifyInvariants(m, inv.Aliens()))
 		}
 	}
+	require.NoError(t, verifier.Done())
 	require.NoError(t, VerifyInvariants(m, inv.Aliens()))
 }
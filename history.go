@@ -0,0 +1,140 @@
+package invasion
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Visit is a city that alien invaded.
+type Visit struct {
+	// Step when alien invaded the city.
+	Step int    `json:"step"`
+	City string `json:"city"`
+	// Direction of the route that alien took, zero for the city where alien spawned.
+	Direction Direction `json:"direction,omitempty"`
+}
+
+// NewHistory creates history that keeps at most limit latest visits, zero or negative limit keeps all visits.
+func NewHistory(limit int) *History {
+	if limit < 0 {
+		limit = 0
+	}
+	return &History{limit: limit}
+}
+
+// History is a path of the alien. If the limit is reached the oldest visit is dropped for every new one,
+// so memory doesn't grow with the number of moves.
+//
+// Methods can be called on nil History, which doesn't record anything.
+type History struct {
+	limit int
+	// visits is used as a ring buffer when limit is reached, start is an index of the oldest visit
+	visits  []Visit
+	start   int
+	dropped int
+	// cities counts recorded visits of every city
+	cities map[string]int
+}
+
+// Add records the visit.
+func (h *History) Add(v Visit) {
+	if h == nil {
+		return
+	}
+	if h.cities == nil {
+		h.cities = map[string]int{}
+	}
+	h.cities[v.City]++
+	if h.limit == 0 || len(h.visits) < h.limit {
+		h.visits = append(h.visits, v)
+		return
+	}
+	if old := h.visits[h.start].City; h.cities[old] == 1 {
+		delete(h.cities, old)
+	} else {
+		h.cities[old]--
+	}
+	h.visits[h.start] = v
+	h.start = (h.start + 1) % len(h.visits)
+	h.dropped++
+}
+
+// Visited returns true if the city is among recorded visits.
+func (h *History) Visited(city string) bool {
+	if h == nil {
+		return false
+	}
+	return h.cities[city] > 0
+}
+
+// Visits returns recorded visits, from the oldest to the latest.
+func (h *History) Visits() []Visit {
+	if h == nil {
+		return nil
+	}
+	rst := make([]Visit, 0, len(h.visits))
+	rst = append(rst, h.visits[h.start:]...)
+	return append(rst, h.visits[:h.start]...)
+}
+
+// Last returns the latest visit, false if nothing was recorded.
+func (h *History) Last() (Visit, bool) {
+	if h == nil || len(h.visits) == 0 {
+		return Visit{}, false
+	}
+	return h.visits[(h.start+len(h.visits)-1)%len(h.visits)], true
+}
+
+// Len returns number of recorded visits.
+func (h *History) Len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.visits)
+}
+
+// Dropped returns number of visits that were dropped because the limit was reached.
+func (h *History) Dropped() int {
+	if h == nil {
+		return 0
+	}
+	return h.dropped
+}
+
+type jsonHistory struct {
+	Limit   int     `json:"limit"`
+	Dropped int     `json:"dropped,omitempty"`
+	Visits  []Visit `json:"visits"`
+}
+
+// MarshalJSON encodes limit, number of dropped visits and visits from the oldest to the latest.
+func (h *History) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonHistory{Limit: h.limit, Dropped: h.dropped, Visits: h.Visits()})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (h *History) UnmarshalJSON(data []byte) error {
+	var jh jsonHistory
+	if err := json.Unmarshal(data, &jh); err != nil {
+		return err
+	}
+	if jh.Limit < 0 || (jh.Limit > 0 && len(jh.Visits) > jh.Limit) {
+		return fmt.Errorf("history with %d visits exceeds limit %d", len(jh.Visits), jh.Limit)
+	}
+	*h = History{limit: jh.Limit, dropped: jh.Dropped, visits: jh.Visits, cities: map[string]int{}}
+	for _, v := range jh.Visits {
+		h.cities[v.City]++
+	}
+	return nil
+}
+
+// Heatmap returns number of visits of every city by the aliens, only recorded visits are counted.
+func Heatmap(aliens []*Alien) map[string]int {
+	rst := map[string]int{}
+	for _, a := range aliens {
+		for _, v := range a.History.Visits() {
+			rst[v.City]++
+		}
+	}
+	return rst
+}
@@ -0,0 +1,146 @@
+package invasion
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryLimit(t *testing.T) {
+	h := NewHistory(3)
+	for i := 1; i <= 5; i++ {
+		h.Add(Visit{Step: i})
+	}
+	require.Equal(t, []Visit{{Step: 3}, {Step: 4}, {Step: 5}}, h.Visits())
+	require.Equal(t, 3, h.Len())
+	require.Equal(t, 2, h.Dropped())
+	last, exist := h.Last()
+	require.True(t, exist)
+	require.Equal(t, Visit{Step: 5}, last)
+
+	unbounded := NewHistory(0)
+	for i := 1; i <= 5; i++ {
+		unbounded.Add(Visit{Step: i})
+	}
+	require.Equal(t, 5, unbounded.Len())
+	require.Zero(t, unbounded.Dropped())
+
+	negative := NewHistory(-5)
+	negative.Add(Visit{Step: 1})
+	require.Equal(t, []Visit{{Step: 1}}, negative.Visits())
+
+	var disabled *History
+	disabled.Add(Visit{Step: 1})
+	require.Empty(t, disabled.Visits())
+	_, exist = disabled.Last()
+	require.False(t, exist)
+}
+
+func TestHistoryJSON(t *testing.T) {
+	h := NewHistory(2)
+	h.Add(Visit{Step: 1, City: "foo"})
+	h.Add(Visit{Step: 2, City: "bar", Direction: North})
+	h.Add(Visit{Step: 3, City: "baz", Direction: South})
+
+	data, err := json.Marshal(h)
+	require.NoError(t, err)
+	recovered := &History{}
+	require.NoError(t, json.Unmarshal(data, recovered))
+	require.Equal(t, h.Visits(), recovered.Visits())
+	require.Equal(t, h.Dropped(), recovered.Dropped())
+
+	recovered.Add(Visit{Step: 4, City: "foo", Direction: North})
+	require.Equal(t, 2, recovered.Len())
+
+	require.Error(t, json.Unmarshal([]byte(`{"limit": 1, "visits": [{"step": 1}, {"step": 2}]}`), recovered))
+}
+
+func TestInvasionHistory(t *testing.T) {
+	data := mapText(t, GenerateMap(rand.New(rand.NewSource(23)), 100, 150))
+	for _, limit := range []int{0, 5} {
+		for _, engine := range []Engine{EngineSerial, EngineParallel} {
+			collected := &collectSink{}
+			inv := NewInvasion(NewMapFromString(data), WithEngine(engine), WithSeed(23), WithAliens(50), WithMoves(50),
+				WithHistory(limit), WithSink(collected))
+			_, err := inv.Run(context.Background())
+			require.NoError(t, err)
+
+			expected := map[int][]Visit{}
+			for _, ev := range collected.evs {
+				switch ev.Type {
+				case AlienSpawned, AlienMoved:
+					id := ev.Aliens[0]
+					expected[id] = append(expected[id], Visit{Step: ev.Step, City: ev.City, Direction: ev.Direction})
+				}
+			}
+			aliens := inv.AllAliens()
+			require.Len(t, aliens, 50)
+			dead := 0
+			for _, a := range aliens {
+				require.Equal(t, a, inv.Alien(a.ID))
+				if a.Dead {
+					dead++
+				}
+				visits := expected[a.ID]
+				if limit > 0 && len(visits) > limit {
+					visits = visits[len(visits)-limit:]
+				}
+				require.Equal(t, len(expected[a.ID])-len(visits), a.History.Dropped(), "alien %d", a.ID)
+				require.Equal(t, visits, a.History.Visits(), "alien %d", a.ID)
+			}
+			require.Equal(t, inv.Stats().Dead, dead)
+			if limit == 0 {
+				total := 0
+				for _, n := range Heatmap(aliens) {
+					total += n
+				}
+				require.Equal(t, countEvents(collected.evs, AlienSpawned, AlienMoved), total)
+			}
+		}
+	}
+}
+
+func TestNegativeHistoryLimit(t *testing.T) {
+	inv := NewInvasion(NewMapFromString("Foo north=Bar\n"), WithAliens(2), WithHistory(-5))
+	_, err := inv.Run(context.Background())
+	require.NoError(t, err)
+	for _, a := range inv.AllAliens() {
+		require.Nil(t, a.History)
+	}
+}
+
+func TestHistoryCheckpoint(t *testing.T) {
+	inv := NewInvasion(NewMapFromString("Foo north=Bar\n"), WithAliens(1), WithMoves(12), WithHistory(3))
+	evs, err := inv.RunSteps(context.Background(), 5)
+	require.NoError(t, err)
+	require.Equal(t, 5, evs.Steps)
+	resumed := resume(t, inv)
+	_, err = resumed.Run(context.Background())
+	require.NoError(t, err)
+
+	// spawned in one city and then moved between both cities with every move
+	history := resumed.Alien(0).History
+	require.Equal(t, 9, history.Dropped())
+	visits := history.Visits()
+	require.Len(t, visits, 3)
+	for i, v := range visits {
+		require.Equal(t, 10+i, v.Step)
+	}
+	require.Equal(t, visits[0].City, visits[2].City)
+	require.NotEqual(t, visits[0].City, visits[1].City)
+}
+
+func countEvents(evs []Event, types ...EventType) int {
+	n := 0
+	for _, ev := range evs {
+		for _, t := range types {
+			if ev.Type == t {
+				n++
+			}
+		}
+	}
+	return n
+}
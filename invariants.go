@@ -14,12 +14,19 @@ func VerifyInvariants(m *Map, aliens []*Alien) error {
 	if err := verifyCitiesState(m); err != nil {
 		return err
 	}
-
-	// TODO it is possible to verify state transitions using events generated during execution
+	// state transitions are verified by TransitionVerifier using events generated during execution
 	return nil
 }
 
 func verifyAlienValidState(m *Map, a *Alien) error {
+	// alien that is alive must be an occupant of the city where it is
+	if !a.Dead && len(a.Location) > 0 {
+		city := m.GetCity(a.Location)
+		if city == nil || !containsInt(city.Occupants, a.ID) {
+			return fmt.Errorf("alien %d is not an occupant of the city %v", a.ID, a.Location)
+		}
+	}
+
 	// to be dead alien needs to find a path to someone else
 	if a.Trapped && a.Dead {
 		return fmt.Errorf("alien %d is dead and trapped at the same time", a.ID)
@@ -36,10 +43,10 @@ func verifyAlienValidState(m *Map, a *Alien) error {
 		}
 	}
 
-	// if dead city should not exists or atleast be destroyed
+	// if dead city should not exists, be destroyed or at least the alien is not an occupant
 	if a.Dead {
 		city := m.GetCity(a.Location)
-		if city != nil && !city.Destroyed {
+		if city != nil && !city.Destroyed && containsInt(city.Occupants, a.ID) {
 			return fmt.Errorf("city %v is not destroyed while alien %d died in it", a.Location, a.ID)
 		}
 	}
@@ -53,16 +60,108 @@ func verifyCitiesState(m *Map) (err error) {
 
 	// alien can't invade without leaving previous city
 	m.IterateCities(func(c *City, _ []Route) bool {
-		if c.Invaded {
-			if name, exist := invaders[c.Invader]; exist {
-				err = fmt.Errorf("alien %d invaded two cities %s and %s", c.Invader, name, c.Name)
+		for _, id := range c.Occupants {
+			if name, exist := invaders[id]; exist {
+				err = fmt.Errorf("alien %d invaded two cities %s and %s", id, name, c.Name)
 				return false
 			}
-			invaders[c.Invader] = c.Name
+			invaders[id] = c.Name
 		}
-
-		// TODO test that routes are symmetric
 		return true
 	})
-	return
+	if err != nil {
+		return err
+	}
+	return m.Validate()
+}
+
+// NewTransitionVerifier creates verifier for the simulation that started with the map m, where every alien
+// can make at most maxMoves and aliens fight when threshold of them is in the same city.
+// Verifier updates m with every event, so it must be a copy of the map used by the simulation.
+// Zero maxMoves doesn't limit moves, same as in the simulation. Threshold lower than 2 uses DefaultFightThreshold.
+func NewTransitionVerifier(m *Map, maxMoves, threshold int) *TransitionVerifier {
+	return &TransitionVerifier{
+		replayer: NewReplayer(m, threshold),
+		maxMoves: maxMoves,
+	}
+}
+
+// TransitionVerifier verifies that every event emitted by the simulation is a valid transition
+// from the state built by previous events. Events are applied with Replayer, so that every check of the
+// replayer is done as well:
+//
+// - alien must leave the city before invading another one, using one of the routes
+// - aliens die only when threshold of them is in the same city, in the same step with the destruction or damage
+// of the city where they fought
+// - only aliens that fought can survive the fight, and only if the city wasn't destroyed
+// - moves never exceed max moves, and every move is counted
+// - trapped aliens never move again
+type TransitionVerifier struct {
+	replayer *Replayer
+	maxMoves int
+}
+
+// Verify verifies single event and updates state of the verifier.
+func (v *TransitionVerifier) Verify(ev Event) error {
+	r := v.replayer
+	if r.arrival != nil && ev.Step != r.step {
+		return fmt.Errorf("alien %d invaded city %s without a fight in step %d", r.arrival.ID, r.arrival.Location, r.step)
+	}
+	if r.fought != nil && ev.Step != r.step {
+		return fmt.Errorf("aliens %v fought without destroying or damaging city %s in step %d", r.fighters, r.fought.ID, r.step)
+	}
+	if len(ev.Aliens) > 0 {
+		if v.maxMoves > 0 && ev.Moves > v.maxMoves {
+			return fmt.Errorf("alien %d made %d moves, more than %d", ev.Aliens[0], ev.Moves, v.maxMoves)
+		}
+		switch ev.Type {
+		case AlienSpawned, AlienMoved, AlienTrapped:
+			// replayer doesn't know moves of the trapped alien, but every such event is a move
+			moves := 0
+			if a, exist := r.aliens[ev.Aliens[0]]; exist {
+				moves = a.Moves
+			}
+			if ev.Moves <= moves {
+				return fmt.Errorf("alien %d moves didn't increase from %d at step %d", ev.Aliens[0], moves, ev.Step)
+			}
+		case AlienExhausted:
+			if ev.Moves != v.maxMoves {
+				return fmt.Errorf("alien %d exhausted after %d moves, expected %d", ev.Aliens[0], ev.Moves, v.maxMoves)
+			}
+		}
+	}
+	return r.Apply(ev)
+}
+
+// Done verifies that simulation didn't stop in the middle of the transition.
+func (v *TransitionVerifier) Done() error {
+	r := v.replayer
+	if r.arrival != nil {
+		return fmt.Errorf("alien %d invaded city %s without a fight", r.arrival.ID, r.arrival.Location)
+	}
+	if r.fought != nil {
+		return fmt.Errorf("aliens %v fought without destroying or damaging city %s", r.fighters, r.fought.ID)
+	}
+	return nil
+}
+
+func containsInt(ints []int, x int) bool {
+	for _, i := range ints {
+		if i == x {
+			return true
+		}
+	}
+	return false
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,132 @@
+package invasion
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// requireConsistent executes invasion created with opts step by step, verifies every state transition and
+// invariants after every step, and checks that the event log is replayed into the same map.
+// Moves and fight threshold are passed to the invasion, verifier and replayer.
+// Events of every step are passed to observe, if it is not nil. Name is used in failure messages.
+func requireConsistent(t *testing.T, name, data string, moves, threshold int, observe func(Invasion, []Event), opts ...Option) Invasion {
+	m := NewMapFromString(data)
+	log := bytes.NewBuffer(nil)
+	sink := NewJSONLSink(log)
+	inv := NewInvasion(m, append(opts, WithMoves(moves), WithFightThreshold(threshold))...)
+	verifier := NewTransitionVerifier(NewMapFromString(data), moves, threshold)
+	for !inv.Done() {
+		evs := inv.Step()
+		for _, ev := range evs {
+			require.NoError(t, verifier.Verify(ev), name)
+			require.NoError(t, sink.Handle(ev))
+		}
+		require.NoError(t, VerifyInvariants(m, inv.Aliens()), name)
+		if observe != nil {
+			observe(inv, evs)
+		}
+	}
+	require.NoError(t, verifier.Done(), name)
+
+	replayer := NewReplayer(NewMapFromString(data), threshold)
+	require.NoError(t, replayer.Replay(NewJSONLReader(log), -1), name)
+	expected, err := json.Marshal(m)
+	require.NoError(t, err)
+	replayed, err := json.Marshal(replayer.Map())
+	require.NoError(t, err)
+	require.Equal(t, string(expected), string(replayed), name)
+	return inv
+}
+
+func TestTransitionVerifierSimulation(t *testing.T) {
+	r := rand.New(rand.NewSource(11))
+	data := mapText(t, GenerateMap(r, 300, 400))
+	inv := NewSerialInvasion(NewMapFromString(data), r, ioutil.Discard, 100, 50)
+	verifier := NewTransitionVerifier(NewMapFromString(data), 50, DefaultFightThreshold)
+	for !inv.Done() {
+		for _, ev := range inv.Step() {
+			require.NoError(t, verifier.Verify(ev))
+		}
+	}
+	require.NoError(t, verifier.Done())
+}
+
+func TestTransitionVerifierViolations(t *testing.T) {
+	data := `Foo north=Bar
+Bar north=Baz
+Baz
+`
+	spawn := func(step, alien, moves int, city string) Event {
+		return Event{Type: AlienSpawned, Step: step, Aliens: []int{alien}, Moves: moves, City: city}
+	}
+	move := func(step, alien, moves int, from, city string) Event {
+		return Event{Type: AlienMoved, Step: step, Aliens: []int{alien}, Moves: moves, From: from, City: city, Direction: North}
+	}
+	for _, tc := range []struct {
+		desc   string
+		events []Event
+	}{
+		{"invaded without leaving", []Event{
+			spawn(1, 0, 1, "foo"),
+			spawn(2, 0, 2, "bar"),
+		}},
+		{"moved from another city", []Event{
+			spawn(1, 0, 1, "foo"),
+			move(2, 0, 2, "baz", "bar"),
+		}},
+		{"trapped moved", []Event{
+			spawn(1, 0, 1, "foo"),
+			{Type: AlienTrapped, Step: 2, Aliens: []int{0}, Moves: 2, City: "foo"},
+			move(3, 0, 3, "foo", "bar"),
+		}},
+		{"too many moves", []Event{
+			spawn(1, 0, 1, "foo"),
+			move(2, 0, 2, "foo", "bar"),
+			move(3, 0, 3, "bar", "baz"),
+		}},
+		{"died without destruction", []Event{
+			spawn(1, 0, 1, "foo"),
+			spawn(2, 1, 1, "foo"),
+			{Type: AliensFought, Step: 2, Aliens: []int{1, 0}, City: "foo"},
+			spawn(3, 2, 1, "bar"),
+		}},
+		{"invaded without fight", []Event{
+			spawn(1, 0, 1, "foo"),
+			spawn(2, 1, 1, "foo"),
+			spawn(3, 2, 1, "bar"),
+		}},
+		{"invaded destroyed city", []Event{
+			spawn(1, 0, 1, "foo"),
+			spawn(2, 1, 1, "foo"),
+			{Type: AliensFought, Step: 2, Aliens: []int{1, 0}, City: "foo"},
+			{Type: CityDestroyed, Step: 2, Aliens: []int{1, 0}, City: "foo"},
+			spawn(3, 2, 1, "foo"),
+		}},
+		{"dead moved", []Event{
+			spawn(1, 0, 1, "foo"),
+			spawn(2, 1, 1, "foo"),
+			{Type: AliensFought, Step: 2, Aliens: []int{1, 0}, City: "foo"},
+			{Type: CityDestroyed, Step: 2, Aliens: []int{1, 0}, City: "foo"},
+			move(3, 0, 2, "foo", "bar"),
+		}},
+	} {
+		verifier := NewTransitionVerifier(NewMapFromString(data), 2, DefaultFightThreshold)
+		var err error
+		for _, ev := range tc.events {
+			if err = verifier.Verify(ev); err != nil {
+				break
+			}
+		}
+		require.Error(t, err, tc.desc)
+	}
+
+	verifier := NewTransitionVerifier(NewMapFromString(data), 2, DefaultFightThreshold)
+	require.NoError(t, verifier.Verify(Event{Type: AlienSpawned, Step: 1, Aliens: []int{0}, Moves: 1, City: "foo"}))
+	require.NoError(t, verifier.Verify(Event{Type: AlienSpawned, Step: 2, Aliens: []int{1}, Moves: 1, City: "foo"}))
+	require.Error(t, verifier.Done())
+}
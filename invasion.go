@@ -1,9 +1,8 @@
 package invasion
 
 import (
-	"fmt"
+	"context"
 	"io"
-	"math/rand"
 	"sort"
 )
 
@@ -11,7 +10,7 @@ import (
 func NewAliens(n int) map[int]*Alien {
 	rst := make(map[int]*Alien, n)
 	for i := 0; i < n; i++ {
-		rst[i] = &Alien{ID: i}
+		rst[i] = &Alien{ID: i, Strength: 1}
 	}
 	return rst
 }
@@ -26,32 +25,57 @@ type Alien struct {
 
 	Location string
 	Trapped  bool
+
+	// Strength is used by fight resolvers, every alien starts with strength 1.
+	Strength int
+	// Faction of the alien, aliens of the same faction don't fight. Aliens without faction fight with everyone.
+	Faction string
+
+	// History of the visited cities, nil if history is not recorded.
+	History *History
 }
 
-// Leave changes city state to univaded and clears alien location.
+// Leave removes alien from the city occupants and clears alien location.
 func (a *Alien) Leave(city *City) {
-	city.Invaded = false
-	city.Invader = -1
+	city.removeOccupant(a.ID)
 	a.Location = ""
 }
 
-// Invade changes city state to invaded and updates alien location.
+// Invade adds alien to the city occupants and updates alien location.
 func (a *Alien) Invade(city *City) {
 	a.Location = city.ID
-	city.Invaded = true
-	city.Invader = a.ID
+	city.Occupants = append(city.Occupants, a.ID)
 }
 
-// FightAt ensures that two aliens die and city is destroyed.
-func (a *Alien) FightAt(contender *Alien, city *City) {
-	a.Dead = true
-	contender.Dead = true
+// FightAt ensures that all aliens die and city is destroyed.
+func FightAt(city *City, aliens ...*Alien) {
+	for _, a := range aliens {
+		a.Die()
+	}
 	city.Destroyed = true
 }
 
+// Die marks alien as dead. Trapped alien is not trapped anymore after death, other aliens could
+// still reach it by spawning in the same city.
+func (a *Alien) Die() {
+	a.Dead = true
+	a.Trapped = false
+}
+
+// DefaultFightThreshold is a number of aliens in the city that start a fight, if threshold is not configured.
+const DefaultFightThreshold = 2
+
 // NewSerialInvasion creates new instance for invasion simulation that executes serially.
-// Map is updated with every state change.
-func NewSerialInvasion(m *Map, r *rand.Rand, notifier io.Writer, aliensCount, moves int) *SerialInvasion {
+// Map is updated with every state change. Use PCG as r to make simulation checkpointable and reproducible across releases.
+func NewSerialInvasion(m *Map, r RNG, notifier io.Writer, aliensCount, moves int) *SerialInvasion {
+	return &SerialInvasion{
+		simulation: newSimulation(m, notifier, aliensCount, moves),
+		r:          r,
+	}
+}
+
+// newSimulation creates state that is shared by all engines.
+func newSimulation(m *Map, notifier io.Writer, aliensCount, moves int) simulation {
 	aliens := NewAliens(aliensCount)
 
 	// sort both so that we don't depend on the implementaton of aliens and maps
@@ -68,46 +92,192 @@ func NewSerialInvasion(m *Map, r *rand.Rand, notifier io.Writer, aliensCount, mo
 		return citiesOrder[i] < citiesOrder[j]
 	})
 
-	return &SerialInvasion{
-		r:           r,
-		notifier:    notifier,
+	return simulation{
+		sinks:       []EventSink{NewTextSink(notifier)},
 		m:           m,
 		aliens:      aliens,
 		aliensOrder: order,
 		citiesOrder: citiesOrder,
 		maxMoves:    moves,
+		threshold:   DefaultFightThreshold,
+		resolver:    DestroyAll(),
+		movement:    Uniform(),
+		movements:   map[int]MovementStrategy{},
+		fallen:      map[int]*Alien{},
 	}
 }
 
-// SerialInvasion used for serial execution of the simulation.
-type SerialInvasion struct {
-	r        *rand.Rand
-	notifier io.Writer
+// simulation is a state of the invasion, engines differ only in the way aliens make progress.
+type simulation struct {
+	sinks []EventSink
 
 	aliensOrder []int
 	aliens      map[int]*Alien
+	// fallen are dead aliens that were removed from aliens, they are kept only for reporting
+	fallen map[int]*Alien
 
 	citiesOrder []string
 	m           *Map
+	// ruins are kept only for reporting, simulation doesn't use them
+	ruins []Ruin
 
 	maxMoves int
+	// step is a number of executed steps
+	step int
+	// dead is a number of aliens that died, they are removed from aliens
+	dead int
+	// budget is a max number of steps, zero doesn't limit steps
+	budget int
+	// threshold is a number of aliens in the city that start a fight
+	threshold int
+	resolver  FightResolver
+	// movement is used by aliens that don't have own strategy in movements
+	movement  MovementStrategy
+	movements map[int]MovementStrategy
+
+	conditions []StopCondition
+	// stopped is the condition that stopped simulation
+	stopped StopCondition
+}
+
+// SerialInvasion used for serial execution of the simulation.
+type SerialInvasion struct {
+	simulation
+	r RNG
+}
+
+// Ruin is a city that was destroyed during invasion, with routes that it had before destruction.
+type Ruin struct {
+	City   *City
+	Routes []Route
+}
+
+// AddSink adds a sink that will receive every event emitted during Run.
+func (si *simulation) AddSink(sink EventSink) {
+	si.sinks = append(si.sinks, sink)
+}
+
+// AddStopCondition adds a condition that can terminate simulation.
+func (si *simulation) AddStopCondition(c StopCondition) {
+	c.Start(si.Stats(), si.Aliens())
+	si.conditions = append(si.conditions, c)
+}
+
+// setMovementStrategy changes how aliens that don't have own strategy pick routes.
+// Strategies that use Alien.History, such as AvoidVisited, enable history without a limit for aliens that
+// don't record it.
+func (si *simulation) setMovementStrategy(s MovementStrategy) {
+	si.movement = s
+	if usesHistory(s) {
+		si.enableHistory(0)
+	}
+}
+
+// setAlienMovementStrategy changes how alien with the id picks routes.
+func (si *simulation) setAlienMovementStrategy(id int, s MovementStrategy) {
+	si.movements[id] = s
+	if a, exist := si.aliens[id]; exist && a.History == nil && usesHistory(s) {
+		a.History = NewHistory(0)
+	}
+}
+
+// enableHistory starts recording visited cities for aliens without history, see WithHistory.
+func (si *simulation) enableHistory(limit int) {
+	if limit < 0 {
+		return
+	}
+	for _, a := range si.aliens {
+		if a.History == nil {
+			a.History = NewHistory(limit)
+		}
+	}
+}
+
+// Alien returns the alien with the id, including dead aliens. Returns nil if there is no such alien.
+func (si *simulation) Alien(id int) *Alien {
+	if a, exist := si.aliens[id]; exist {
+		return a
+	}
+	return si.fallen[id]
+}
+
+// AllAliens returns every alien including dead and aliens that made all moves, ordered by id.
+func (si *simulation) AllAliens() []*Alien {
+	rst := make([]*Alien, 0, len(si.aliens)+len(si.fallen))
+	for _, a := range si.aliens {
+		rst = append(rst, a)
+	}
+	for _, a := range si.fallen {
+		rst = append(rst, a)
+	}
+	sort.Slice(rst, func(i, j int) bool {
+		return rst[i].ID < rst[j].ID
+	})
+	return rst
+}
+
+// movementOf returns movement strategy of the alien.
+func (si *simulation) movementOf(alien *Alien) MovementStrategy {
+	if s, exist := si.movements[alien.ID]; exist {
+		return s
+	}
+	return si.movement
 }
 
-// Run runs invasion until invasion is valid.
-// Prints important events to notifier.
-func (si *SerialInvasion) Run() {
-	for si.Valid() {
-		evs := si.Next()
+// observe passes events of the step to the stop conditions.
+func (si *simulation) observe(evs []Event) {
+	for _, c := range si.conditions {
+		if c.Observe(si.step, evs) && si.stopped == nil {
+			si.stopped = c
+		}
+	}
+}
+
+// runSteps executes at most n steps using next. Negative n doesn't limit steps.
+func (si *simulation) runSteps(ctx context.Context, n int, next func() []Event) (Result, error) {
+	for i := 0; ; i++ {
+		if si.Done() {
+			return Result{Reason: si.stopReason(), Steps: i, Condition: si.stopped}, nil
+		}
+		if n >= 0 && i >= n {
+			return Result{Reason: StopSteps, Steps: i}, nil
+		}
+		if ctx.Err() != nil {
+			return Result{Reason: StopCancelled, Steps: i}, nil
+		}
+		evs := next()
 		for _, ev := range evs {
-			if ev.Important {
-				_, _ = fmt.Fprintln(si.notifier, ev.Data)
+			for _, sink := range si.sinks {
+				if err := sink.Handle(ev); err != nil {
+					return Result{Reason: StopSinkFailed, Steps: i + 1}, err
+				}
 			}
 		}
 	}
 }
 
-// Next advances simulation. All state mutations are inside this method.
-func (si *SerialInvasion) Next() (evs []Event) {
+// Run runs invasion until invasion is done, or until context is cancelled.
+// Prints important events to notifier and passes every event to the sinks.
+// Error is returned only if a sink failed, simulation stops at the first such error.
+func (si *SerialInvasion) Run(ctx context.Context) (Result, error) {
+	return si.RunSteps(ctx, -1)
+}
+
+// RunSteps is the same as Run but executes at most n steps. Negative n doesn't limit steps.
+func (si *SerialInvasion) RunSteps(ctx context.Context, n int) (Result, error) {
+	return si.runSteps(ctx, n, si.Step)
+}
+
+// Next advances simulation, same as Step.
+//
+// Deprecated: use Step.
+func (si *SerialInvasion) Next() []Event {
+	return si.Step()
+}
+
+// Step advances simulation. All state mutations are inside this method.
+// Returns events for every state transition that happened in this step.
+func (si *SerialInvasion) Step() (evs []Event) {
 	// algo:
 	// 1. pick a random alien
 	// 2. increment alien moves
@@ -115,10 +285,11 @@ func (si *SerialInvasion) Next() (evs []Event) {
 	// this city pool must be updated when the city is destroyed
 	// 4. if alien is trapped - map can't be fixed, so we simply ignore him
 	// 5. if alien died in the battle - we will gc the alien
-	// 6. if alien is neither trapped or dead - pick a random city that is reachable from alien
-	// current location. if we can't reach any - trap the alien
-	// 7. if two aliens meet each other at at the same city - they will die, one of them gc'ed immediatly,
-	// and city gc'ed immediatly
+	// 6. if alien is neither trapped or dead - movement strategy picks a city that is reachable from alien
+	// current location, or alien stays. if we can't reach any - trap the alien
+	// 7. if number of aliens at the same city reaches the fight threshold and not all of them are
+	// of the same faction - they will die, alien that arrived
+	// is gc'ed immediatly, others when they are picked, and city gc'ed immediatly
 
 	// pick random alien
 	var (
@@ -126,40 +297,74 @@ func (si *SerialInvasion) Next() (evs []Event) {
 		alien = si.aliens[si.aliensOrder[idx]]
 	)
 	alien.Moves++
+	si.step++
 
 	if len(alien.Location) == 0 && si.m.Size() != 0 {
 
 		// alien starts in random city
 		cidx := si.r.Intn(len(si.citiesOrder))
 		city := si.m.GetCity(si.citiesOrder[cidx])
+		evs = append(evs, si.event(AlienSpawned, city, alien.ID))
+		alien.History.Add(Visit{Step: si.step, City: city.ID})
 		// another alien can start at the same city, so we check for that from the start
-		evs = si.invadeCity(alien, city, evs)
+		evs = si.invadeCity(alien, city, si.r, evs)
 
 	} else if !alien.Trapped && !alien.Dead {
 
-		// if alien already invaded a city, pick a random one based on existing routes
-		city := si.m.GetRandomCityFrom(si.r, alien.Location)
-		if city == nil {
+		// if alien already invaded a city, movement strategy picks a route or alien stays
+		if si.m.RoutesSize(alien.Location) == 0 {
 			// if there are no cities reachable from current location then alien is trapped
 			alien.Trapped = true
-		} else {
+			evs = append(evs, si.event(AlienTrapped, si.m.GetCity(alien.Location), alien.ID))
+		} else if route, move := si.movementOf(alien).Move(alien, si.m, si.r); move {
 			// otherwise try to invade new city
+			city := si.m.GetCity(route.To)
+			ev := si.event(AlienMoved, city, alien.ID)
+			ev.From = alien.Location
+			ev.Direction = route.Direction
+			evs = append(evs, ev)
+			alien.History.Add(Visit{Step: si.step, City: city.ID, Direction: route.Direction})
+
 			alien.Leave(si.m.GetCity(alien.Location))
-			evs = si.invadeCity(alien, city, evs)
+			evs = si.invadeCity(alien, city, si.r, evs)
 		}
 	}
 
 	// gc alien whenever simulation observed his death or he reached max moves
 	if alien.Dead {
 		delete(si.aliens, si.aliensOrder[idx])
+		si.fallen[alien.ID] = alien
 		si.deleteAlienFromOrder(idx)
 	} else if alien.Moves == si.maxMoves {
 		si.deleteAlienFromOrder(idx)
+		evs = append(evs, si.event(AlienExhausted, si.m.GetCity(alien.Location), alien.ID))
 	}
+	si.observe(evs)
 	return evs
 }
 
-func (si *SerialInvasion) deleteCityFromOrder(requested string) {
+// event creates an event for the current step.
+func (si *simulation) event(t EventType, city *City, aliens ...int) Event {
+	ev := Event{Type: t, Step: si.step, Aliens: aliens}
+	if len(aliens) > 0 {
+		ev.Moves = si.aliens[aliens[0]].Moves
+	}
+	for i, id := range aliens {
+		if faction := si.aliens[id].Faction; len(faction) > 0 {
+			if ev.Factions == nil {
+				ev.Factions = make([]string, len(aliens))
+			}
+			ev.Factions[i] = faction
+		}
+	}
+	if city != nil {
+		ev.City = city.ID
+		ev.CityName = city.Name
+	}
+	return ev
+}
+
+func (si *simulation) deleteCityFromOrder(requested string) {
 	idx := -1
 	for i, id := range si.citiesOrder {
 		if requested == id {
@@ -175,7 +380,7 @@ func (si *SerialInvasion) deleteCityFromOrder(requested string) {
 }
 
 // Aliens return slice of aliens that are alive or are dead but yet not garbage collected.
-func (si *SerialInvasion) Aliens() []*Alien {
+func (si *simulation) Aliens() []*Alien {
 	rst := make([]*Alien, 0, len(si.aliensOrder))
 	for _, idx := range si.aliensOrder {
 		rst = append(rst, si.aliens[idx])
@@ -183,31 +388,127 @@ func (si *SerialInvasion) Aliens() []*Alien {
 	return rst
 }
 
-func (si *SerialInvasion) deleteAlienFromOrder(idx int) {
+// Map returns the map that is updated by the simulation.
+func (si *simulation) Map() *Map {
+	return si.m
+}
+
+// Ruins returns cities destroyed during invasion in the order of destruction.
+func (si *simulation) Ruins() []Ruin {
+	return append([]Ruin(nil), si.ruins...)
+}
+
+// located returns aliens that are alive and invaded any city, including aliens that made all moves.
+func (si *simulation) located() []*Alien {
+	rst := make([]*Alien, 0, len(si.aliens))
+	for _, a := range si.aliens {
+		if !a.Dead && len(a.Location) > 0 {
+			rst = append(rst, a)
+		}
+	}
+	sort.Slice(rst, func(i, j int) bool {
+		return rst[i].ID < rst[j].ID
+	})
+	return rst
+}
+
+func (si *simulation) deleteAlienFromOrder(idx int) {
 	last := len(si.aliensOrder) - 1
 	// FIXME copy for last element is unnecessary
 	copy(si.aliensOrder[idx:], si.aliensOrder[idx+1:])
 	si.aliensOrder = si.aliensOrder[:last]
 }
 
-func (si *SerialInvasion) invadeCity(alien *Alien, city *City, evs []Event) []Event {
-	if !city.Invaded {
-		alien.Invade(city)
-	} else {
-		// if city already invaded two aliens will fight, both should die and city should be destroyed
-		contender := si.aliens[city.Invader]
-		alien.FightAt(si.aliens[city.Invader], city)
+// invadeCity moves alien to the city and resolves a fight if the city reached the threshold.
+// Random generator r is used only by the fight resolver.
+func (si *simulation) invadeCity(alien *Alien, city *City, r RNG, evs []Event) []Event {
+	alien.Invade(city)
+	if len(city.Occupants) < si.threshold || peaceful(city.Occupants, si.aliens) {
+		return evs
+	}
+	// if city reached the threshold all occupants will fight, resolver decides who survives and
+	// if the city should be destroyed
+	ids := fighters(city.Occupants)
+	aliens := make([]*Alien, 0, len(ids))
+	for _, id := range ids {
+		aliens = append(aliens, si.aliens[id])
+	}
+	evs = append(evs, si.event(AliensFought, city, ids...))
+	outcome := si.resolver.Resolve(Fight{City: city, Aliens: aliens, RNG: r})
+	if outcome.Destroyed {
+		FightAt(city, aliens...)
+		si.dead += len(aliens)
+		si.ruins = append(si.ruins, Ruin{City: city, Routes: si.m.Routes(city.ID)})
 		si.m.DeleteCity(city.ID)
 		si.deleteCityFromOrder(city.ID)
-		evs = append(evs, NewImportantEvent(
-			"%s has been destroyted by alien %d and alien %d!",
-			city.Name, alien.ID, contender.ID))
+		return append(evs, si.event(CityDestroyed, city, fighters(city.Occupants)...))
 	}
-	return evs
+	survivors := map[int]bool{}
+	for _, a := range outcome.Survivors {
+		survivors[a.ID] = true
+	}
+	for _, a := range aliens {
+		if survivors[a.ID] {
+			evs = append(evs, si.event(AlienSurvived, city, a.ID))
+			continue
+		}
+		// dead alien keeps location for reporting, but it is not an occupant anymore
+		a.Die()
+		city.removeOccupant(a.ID)
+		si.dead++
+	}
+	city.Damage++
+	return append(evs, si.event(CityDamaged, city, ids...))
 }
 
-// Valid if any alien can move and map is not empty.
-func (si *SerialInvasion) Valid() bool {
-	// we remove dead or exhausted aliens from aliens order
-	return si.m.Size() > 0 && len(si.aliensOrder) > 0
+// Valid returns true if simulation can make progress.
+//
+// Deprecated: use Done.
+func (si *simulation) Valid() bool {
+	return !si.Done()
+}
+
+// Done returns true if map is empty, none of the aliens can move, stop condition is met or step budget is exhausted.
+func (si *simulation) Done() bool {
+	return si.stopReason() != 0
+}
+
+// stopReason returns a reason why simulation can't make progress, or zero if it can.
+func (si *simulation) stopReason() StopReason {
+	switch {
+	case si.m.Size() == 0:
+		return StopMapEmpty
+	case len(si.aliensOrder) == 0:
+		// we remove dead or exhausted aliens from aliens order
+		return StopAliensDone
+	case si.stopped != nil:
+		return StopConditionMet
+	case si.budget > 0 && si.step >= si.budget:
+		return StopBudget
+	}
+	return 0
+}
+
+// Stats returns counters that describe current state of the simulation.
+func (si *simulation) Stats() Stats {
+	stats := Stats{
+		Steps:     si.step,
+		Cities:    si.m.Size(),
+		Destroyed: len(si.ruins),
+		Dead:      si.dead,
+	}
+	for _, a := range si.aliens {
+		// dead aliens are garbage collected only when they are picked
+		if a.Dead {
+			continue
+		}
+		stats.Aliens++
+		if a.Trapped {
+			stats.Trapped++
+		}
+		if a.Moves == si.maxMoves {
+			stats.Exhausted++
+		}
+	}
+	return stats
 }
@@ -2,6 +2,10 @@ package invasion
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"testing"
@@ -24,16 +28,16 @@ Bam
 
 	inv := NewSerialInvasion(NewMapFromString(data), rand.New(rand.NewSource(seed)), ioutil.Discard, 3, 10)
 	first := []Event{}
-	for inv.Valid() {
-		for _, ev := range inv.Next() {
+	for !inv.Done() {
+		for _, ev := range inv.Step() {
 			first = append(first, ev)
 		}
 	}
 
 	inv = NewSerialInvasion(NewMapFromString(data), rand.New(rand.NewSource(seed)), ioutil.Discard, 3, 10)
 	second := []Event{}
-	for inv.Valid() {
-		for _, ev := range inv.Next() {
+	for !inv.Done() {
+		for _, ev := range inv.Step() {
 			second = append(second, ev)
 		}
 	}
@@ -48,8 +52,8 @@ En
 
 	inv := NewSerialInvasion(NewMapFromString(data), rand.New(rand.NewSource(time.Now().UnixNano())),
 		ioutil.Discard, 1, 10)
-	inv.Next()
-	inv.Next()
+	inv.Step()
+	inv.Step()
 	aliens := inv.Aliens()
 
 	// one or three alien will be trapped, 2 aliens may get destroyed and gc'ed if they invade same city initially
@@ -70,13 +74,108 @@ Bam east=En
 	inv := NewSerialInvasion(
 		NewMapFromString(data), rand.New(rand.NewSource(time.Now().UnixNano())),
 		ioutil.Discard, 1, moves)
-	inv.Run()
+	inv.Run(context.Background())
 
 	aliens := inv.Aliens()
 
 	require.Empty(t, aliens)
 }
 
+func TestSerialInvasionTrappedEvents(t *testing.T) {
+	inv := NewSerialInvasion(NewMapFromString("En\n"), rand.New(rand.NewSource(1)), ioutil.Discard, 1, 3)
+	evs := []Event{}
+	for !inv.Done() {
+		evs = append(evs, inv.Step()...)
+	}
+	require.Equal(t, []Event{
+		{Type: AlienSpawned, Step: 1, Aliens: []int{0}, Moves: 1, City: "en", CityName: "En"},
+		{Type: AlienTrapped, Step: 2, Aliens: []int{0}, Moves: 2, City: "en", CityName: "En"},
+		{Type: AlienExhausted, Step: 3, Aliens: []int{0}, Moves: 3, City: "en", CityName: "En"},
+	}, evs)
+}
+
+func TestSerialInvasionFightEvents(t *testing.T) {
+	inv := NewSerialInvasion(NewMapFromString("En east=Bam\n"), rand.New(rand.NewSource(1)), ioutil.Discard, 2, 10)
+	evs := []Event{}
+	for !inv.Done() {
+		evs = append(evs, inv.Step()...)
+	}
+	moved := 0
+	for i, ev := range evs {
+		switch ev.Type {
+		case AlienMoved:
+			moved++
+			require.Len(t, ev.Aliens, 1)
+			require.NotEqual(t, ev.From, ev.City)
+			require.True(t, ev.Direction.Valid())
+		case AliensFought:
+			require.Len(t, ev.Aliens, 2)
+			require.True(t, i+1 < len(evs))
+			destroyed := evs[i+1]
+			require.Equal(t, CityDestroyed, destroyed.Type)
+			require.Equal(t, ev.Step, destroyed.Step)
+			require.Equal(t, ev.City, destroyed.City)
+			require.True(t, destroyed.Important())
+			require.Equal(t, fmt.Sprintf("%s has been destroyed by alien %d and alien %d!",
+				destroyed.CityName, destroyed.Aliens[0], destroyed.Aliens[1]), destroyed.String())
+		}
+	}
+	require.True(t, moved > 0, "aliens never moved")
+}
+
+type collectSink struct {
+	evs []Event
+}
+
+func (c *collectSink) Handle(ev Event) error {
+	c.evs = append(c.evs, ev)
+	return nil
+}
+
+// runCollect runs invasion until it is done and returns every emitted event.
+func runCollect(t testing.TB, inv Invasion) []Event {
+	collected := &collectSink{}
+	inv.AddSink(collected)
+	_, err := inv.Run(context.Background())
+	require.NoError(t, err)
+	return collected.evs
+}
+
+func TestSerialInvasionJSONLSink(t *testing.T) {
+	m := GenerateMap(rand.New(rand.NewSource(2)), 50, 60)
+	inv := NewSerialInvasion(m, rand.New(rand.NewSource(2)), ioutil.Discard, 10, 100)
+	buf := bytes.NewBuffer(nil)
+	collected := &collectSink{}
+	inv.AddSink(NewJSONLSink(buf))
+	inv.AddSink(collected)
+	_, err := inv.Run(context.Background())
+	require.NoError(t, err)
+	require.NotEmpty(t, collected.evs)
+
+	dec := json.NewDecoder(buf)
+	decoded := []Event{}
+	for dec.More() {
+		var ev Event
+		require.NoError(t, dec.Decode(&ev))
+		decoded = append(decoded, ev)
+	}
+	require.Equal(t, collected.evs, decoded)
+}
+
+func TestSerialInvasionSinkError(t *testing.T) {
+	inv := NewSerialInvasion(NewMapFromString("En\n"), rand.New(rand.NewSource(1)), ioutil.Discard, 1, 10)
+	inv.AddSink(NewJSONLSink(failingWriter{}))
+	_, err := inv.Run(context.Background())
+	require.Error(t, err)
+	require.False(t, inv.Done())
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("failed")
+}
+
 func BenchmarkSerialInvasion100(b *testing.B) {
 	r := rand.New(rand.NewSource(100))
 	m := GenerateMap(r, 1000, 750)
@@ -90,10 +189,30 @@ func BenchmarkSerialInvasion100(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		inv := NewSerialInvasion(m, r, ioutil.Discard, 100, 10000)
-		inv.Run()
+		inv.Run(context.Background())
 
 		b.StopTimer()
 		m = NewMapFromString(data)
 		b.StartTimer()
 	}
 }
+
+func TestSerialInvasionStableAcrossReleases(t *testing.T) {
+	// changing the expected output breaks compatibility guarantee of the PCG seeds
+	data := `Foo123 south=Baz north=Tot-H
+Tot-H east=Bar
+Baz north=Foo123
+Bar
+`
+	notifier := bytes.NewBuffer(nil)
+	inv := NewSerialInvasion(NewMapFromString(data), NewPCG(777, 0), notifier, 4, 7)
+	_, err := inv.Run(context.Background())
+	require.NoError(t, err)
+	_, err = inv.m.WriteTo(notifier)
+	require.NoError(t, err)
+	require.Equal(t, `Tot-H has been destroyed by alien 3 and alien 1!
+Bar
+Baz north=Foo123
+Foo123 south=Baz
+`, notifier.String())
+}
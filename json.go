@@ -0,0 +1,160 @@
+package invasion
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// jsonMap is a representation of the map in json format:
+//
+//	{
+//	  "headers": [{"key": "name", "value": "example"}],
+//	  "cities": [
+//	    {"id": "bar", "name": "Bar", "routes": [{"to": "foo", "direction": "north"}]},
+//	    {"id": "foo", "name": "Foo", "occupants": [1], "routes": [{"to": "bar", "direction": "south"}]}
+//	  ]
+//	}
+type jsonMap struct {
+	Headers []Header   `json:"headers,omitempty"`
+	Cities  []jsonCity `json:"cities"`
+}
+
+type jsonCity struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Occupants []int  `json:"occupants,omitempty"`
+	// Invader is read for compatibility with maps written before cities had multiple occupants.
+	Invader   *int    `json:"invader,omitempty"`
+	Destroyed bool    `json:"destroyed,omitempty"`
+	Damage    int     `json:"damage,omitempty"`
+	Routes    []Route `json:"routes"`
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Direction) MarshalText() ([]byte, error) {
+	if !d.Valid() {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownDirection, d)
+	}
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Direction) UnmarshalText(text []byte) error {
+	parsed, err := ParseDirection(string(text))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// MarshalJSON encodes headers, cities with their state and routes. Cities are ordered by id,
+// routes are in the same order as on the map.
+func (m *Map) MarshalJSON() ([]byte, error) {
+	jm := jsonMap{
+		Headers: m.headers,
+		Cities:  make([]jsonCity, 0, len(m.cities)),
+	}
+	m.IterateCities(func(c *City, routes []Route) bool {
+		jc := jsonCity{
+			ID:        c.ID,
+			Name:      c.Name,
+			Occupants: c.Occupants,
+			Destroyed: c.Destroyed,
+			Damage:    c.Damage,
+			Routes:    routes,
+		}
+		if jc.Routes == nil {
+			jc.Routes = []Route{}
+		}
+		jm.Cities = append(jm.Cities, jc)
+		return true
+	})
+	return json.Marshal(jm)
+}
+
+// UnmarshalJSON adds headers, cities and routes to the map. Same as with ReadFrom cities that are already
+// on the map are reused and their state is preserved.
+// Routes are added in the same order as in the data, missing reverse routes are restored after all cities
+// were added. If data is not valid the map is left unchanged.
+func (m *Map) UnmarshalJSON(data []byte) error {
+	var jm jsonMap
+	if err := json.Unmarshal(data, &jm); err != nil {
+		return fmt.Errorf("%w: %v", ErrUnexpectedFormat, err)
+	}
+	decoded := m.clone()
+	if err := decoded.addJSON(&jm); err != nil {
+		return err
+	}
+	*m = *decoded
+	return nil
+}
+
+func (m *Map) addJSON(jm *jsonMap) error {
+	for _, h := range jm.Headers {
+		if err := m.SetHeader(h.Key, h.Value); err != nil {
+			return err
+		}
+	}
+	for _, jc := range jm.Cities {
+		if id := strings.ToLower(jc.Name); id != jc.ID {
+			return fmt.Errorf("%w: city %q has id %q, expected %q", ErrUnexpectedFormat, jc.Name, jc.ID, id)
+		}
+		city, exists, err := m.lookupCity(jc.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		city.Occupants = jc.occupants()
+		city.Destroyed = jc.Destroyed
+		city.Damage = jc.Damage
+		m.AddCity(city)
+	}
+	// first add routes only in one direction to preserve the order, and then restore reverse routes
+	for _, jc := range jm.Cities {
+		for _, r := range jc.Routes {
+			if err := m.verifyJSONRoute(jc.ID, r); err != nil {
+				return err
+			}
+			exists, err := m.verifyRoute(jc.ID, r.To, r.Direction)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				m.addRoute(jc.ID, r.To, r.Direction)
+			}
+		}
+	}
+	for _, jc := range jm.Cities {
+		for _, r := range jc.Routes {
+			if err := m.AddRoute(jc.ID, r.To, r.Direction); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// occupants returns occupants of the city, including the invader from the older format.
+func (jc *jsonCity) occupants() []int {
+	if len(jc.Occupants) == 0 && jc.Invader != nil {
+		return []int{*jc.Invader}
+	}
+	return jc.Occupants
+}
+
+func (m *Map) verifyJSONRoute(from string, r Route) error {
+	if !r.Direction.Valid() {
+		return fmt.Errorf("%w: route from %v to %v", ErrUnknownDirection, from, r.To)
+	}
+	if from == r.To {
+		return fmt.Errorf("%w: %s", ErrSelfRoute, from)
+	}
+	if m.GetCity(r.To) == nil {
+		return fmt.Errorf("%w: route from %v to unknown city %v", ErrUnexpectedFormat, from, r.To)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package invasion
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	original := NewMapFromString(`@name example
+Foo south=Baz north="Tot H"
+"Tot H" east=Bar
+Baz
+Bar
+`)
+	foo := original.GetCity("foo")
+	foo.Occupants = []int{0, 3}
+
+	data, err := json.Marshal(original)
+	require.NoError(t, err)
+
+	recovered := NewMap()
+	require.NoError(t, json.Unmarshal(data, recovered))
+	require.Equal(t, original, recovered)
+}
+
+func TestJSONLegacyInvader(t *testing.T) {
+	m := NewMap()
+	require.NoError(t, json.Unmarshal([]byte(`{"cities": [{"id": "foo", "name": "Foo", "invaded": true, "invader": 3, "routes": []}]}`), m))
+	require.Equal(t, []int{3}, m.GetCity("foo").Occupants)
+}
+
+func TestJSONConsistentWithText(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	m := GenerateMap(r, 100, 150)
+	require.NoError(t, m.SetHeader("seed", "1"))
+
+	text := bytes.NewBuffer(nil)
+	require.NoError(t, m.Encode(text, FormatText))
+
+	encoded := bytes.NewBuffer(nil)
+	require.NoError(t, m.Encode(encoded, FormatJSON))
+
+	decoded := NewMap()
+	require.NoError(t, decoded.Decode(encoded, FormatJSON))
+	require.Equal(t, m, decoded)
+
+	recovered := bytes.NewBuffer(nil)
+	require.NoError(t, decoded.Encode(recovered, FormatText))
+	require.Equal(t, text.String(), recovered.String())
+}
+
+func TestJSONInvalidMap(t *testing.T) {
+	for _, data := range []string{
+		`{"cities": [{"id": "foo", "name": "Foo", "routes": [{"to": "bar", "direction": "north"}]}]}`,
+		`{"cities": [{"id": "foo", "name": "Foo", "routes": [{"to": "foo", "direction": "north"}]}]}`,
+		`{"cities": [{"id": "Foo", "name": "Foo", "routes": []}]}`,
+		`{"cities": [{"id": "foo", "name": "Foo", "routes": []}, {"id": "bar", "name": "Bar", "routes": [{"to": "foo", "direction": "up"}]}]}`,
+		`{"cities": [`,
+	} {
+		err := NewMap().Decode(bytes.NewBufferString(data), FormatJSON)
+		require.True(t, errors.Is(err, ErrUnexpectedFormat), "%s: error is %v", data, err)
+	}
+}
+
+func TestJSONInvalidMapUnchanged(t *testing.T) {
+	m := NewMapFromString("Foo north=Bar\n")
+	expected := mapText(t, m)
+	data := `{"cities": [
+{"id": "a", "name": "A", "routes": [{"to": "b", "direction": "north"}]},
+{"id": "b", "name": "B", "routes": []},
+{"id": "c", "name": "C", "routes": [{"to": "b", "direction": "north"}]}
+]}`
+	err := json.Unmarshal([]byte(data), m)
+	require.True(t, errors.Is(err, ErrConflictingRoute), "error is %v", err)
+	require.Equal(t, expected, mapText(t, m))
+	require.NoError(t, m.Validate())
+}
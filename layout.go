@@ -0,0 +1,188 @@
+package invasion
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrInconsistentLayout returned if routes can't be placed on a grid according to their directions.
+var ErrInconsistentLayout = errors.New("Inconsistent layout")
+
+// Point is a position on the grid. X grows to the east and Y grows to the south.
+type Point struct {
+	X, Y int
+}
+
+// Move returns a point next to p in the direction d.
+func (p Point) Move(d Direction) Point {
+	switch d {
+	case North:
+		p.Y--
+	case South:
+		p.Y++
+	case East:
+		p.X++
+	case West:
+		p.X--
+	}
+	return p
+}
+
+// Edge is a route together with the city where it starts.
+type Edge struct {
+	From string
+	Route
+}
+
+// Layout is an embedding of the map on a grid, every city has a unique position.
+//
+// Position of the city is inferred from directions, e.g. if Foo is north of Bar it is placed
+// right above it. Some maps can't be embedded this way, e.g. if loop of routes doesn't end where
+// it started, or two cities end up at the same position. Such maps are split into fragments
+// that are consistent and every fragment is placed separately. Routes that can't be drawn
+// in their direction are reported as inconsistent.
+type Layout struct {
+	// Positions of the cities by id.
+	Positions map[string]Point
+	// Width and Height of the grid.
+	Width, Height int
+	// Inconsistent edges are ordered by the id of the city where edge starts. Every route is reported once.
+	Inconsistent []Edge
+}
+
+// fragment is a group of cities with consistent positions relative to each other.
+type fragment struct {
+	ids       []string
+	positions map[string]Point
+	min, max  Point
+}
+
+func (f *fragment) width() int {
+	return f.max.X - f.min.X + 1
+}
+
+func (f *fragment) height() int {
+	return f.max.Y - f.min.Y + 1
+}
+
+// NewLayout computes layout for the map. Result is deterministic.
+func NewLayout(m *Map) *Layout {
+	l := &Layout{Positions: make(map[string]Point, m.Size())}
+	fragments := []*fragment{}
+	m.IterateCities(func(c *City, _ []Route) bool {
+		if _, placed := l.Positions[c.ID]; placed {
+			return true
+		}
+		f := l.expand(m, c.ID)
+		for _, id := range f.ids {
+			// mark as placed, final position is set when fragment is packed
+			l.Positions[id] = f.positions[id]
+		}
+		fragments = append(fragments, f)
+		return true
+	})
+	l.pack(fragments)
+
+	m.IterateCities(func(c *City, routes []Route) bool {
+		for _, r := range routes {
+			if c.ID > r.To {
+				continue
+			}
+			if l.Positions[c.ID].Move(r.Direction) != l.Positions[r.To] {
+				l.Inconsistent = append(l.Inconsistent, Edge{From: c.ID, Route: r})
+			}
+		}
+		return true
+	})
+	return l
+}
+
+// expand walks routes from the root in breadth first order and places every city that is not yet
+// placed and whose position is not taken.
+func (l *Layout) expand(m *Map, root string) *fragment {
+	f := &fragment{
+		ids:       []string{root},
+		positions: map[string]Point{root: {}},
+	}
+	occupied := map[Point]struct{}{{}: {}}
+	for i := 0; i < len(f.ids); i++ {
+		id := f.ids[i]
+		pos := f.positions[id]
+		for _, r := range m.routes[id] {
+			if _, placed := l.Positions[r.To]; placed {
+				continue
+			}
+			if _, placed := f.positions[r.To]; placed {
+				continue
+			}
+			next := pos.Move(r.Direction)
+			if _, taken := occupied[next]; taken {
+				continue
+			}
+			occupied[next] = struct{}{}
+			f.positions[r.To] = next
+			f.ids = append(f.ids, r.To)
+			if next.X < f.min.X {
+				f.min.X = next.X
+			}
+			if next.Y < f.min.Y {
+				f.min.Y = next.Y
+			}
+			if next.X > f.max.X {
+				f.max.X = next.X
+			}
+			if next.Y > f.max.Y {
+				f.max.Y = next.Y
+			}
+		}
+	}
+	return f
+}
+
+// pack places fragments in rows, so that the grid is roughly a square, with an empty cell between fragments.
+func (l *Layout) pack(fragments []*fragment) {
+	area, widest := 0, 0
+	for _, f := range fragments {
+		area += (f.width() + 1) * (f.height() + 1)
+		if f.width() > widest {
+			widest = f.width()
+		}
+	}
+	limit := int(math.Ceil(math.Sqrt(float64(area))))
+	if limit < widest {
+		limit = widest
+	}
+	var x, y, rowHeight int
+	for _, f := range fragments {
+		if x > 0 && x+f.width() > limit {
+			x = 0
+			y += rowHeight + 1
+			rowHeight = 0
+		}
+		for _, id := range f.ids {
+			pos := f.positions[id]
+			l.Positions[id] = Point{X: x + pos.X - f.min.X, Y: y + pos.Y - f.min.Y}
+		}
+		if x+f.width() > l.Width {
+			l.Width = x + f.width()
+		}
+		if f.height() > rowHeight {
+			rowHeight = f.height()
+		}
+		if y+rowHeight > l.Height {
+			l.Height = y + rowHeight
+		}
+		x += f.width() + 1
+	}
+}
+
+// Err returns error that wraps ErrInconsistentLayout if some routes are not drawn in their direction.
+func (l *Layout) Err() error {
+	if len(l.Inconsistent) == 0 {
+		return nil
+	}
+	e := l.Inconsistent[0]
+	return fmt.Errorf("%w: %d routes can't be placed on a grid, first %v %v of %v",
+		ErrInconsistentLayout, len(l.Inconsistent), e.To, e.Direction, e.From)
+}
@@ -6,46 +6,29 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
 	"sort"
 	"strings"
 )
 
 const (
-	north = "north"
-	south = "south"
-	east  = "east"
-	west  = "west"
-
 	maxRoutes = 4
 )
 
 var (
 	// ErrUnexpectedFormat returned if map has items with unexpected format.
 	ErrUnexpectedFormat = errors.New("Unexpected format")
-
-	emptySpace = []byte(" ")
-	equalSign  = []byte("=")
-	newLine    = []byte("\n")
+	// ErrSelfRoute returned if a route leads to the city where it starts.
+	ErrSelfRoute = fmt.Errorf("%w: route to self", ErrUnexpectedFormat)
+	// ErrConflictingRoute returned if a route in the same direction already leads to another city.
+	ErrConflictingRoute = fmt.Errorf("%w: conflicting route", ErrUnexpectedFormat)
+	// ErrNameConflict returned if city name maps to the id of the city with a different name, e.g. Foo and FOO.
+	ErrNameConflict = fmt.Errorf("%w: name conflict", ErrUnexpectedFormat)
+	// ErrUnknownDirection returned if direction is not one of the cardinal directions.
+	ErrUnknownDirection = fmt.Errorf("%w: unknown direction", ErrUnexpectedFormat)
+	// ErrCorruptedMap returned by Map.Validate if routing table is not consistent with cities.
+	ErrCorruptedMap = errors.New("Corrupted map")
 )
 
-// reverseDirection returns a reverse direction for any valid direction.
-// might be used to test validity.
-func reverseDirection(direction string) string {
-	switch direction {
-	case north:
-		return south
-	case south:
-		return north
-	case east:
-		return west
-	case west:
-		return east
-	default:
-		panic("unknown direction: " + direction)
-	}
-}
-
 // NewCity creates instance of the city with a give name and using lowecased name as id.
 func NewCity(name string) *City {
 	return &City{
@@ -59,18 +42,42 @@ type City struct {
 	ID string
 	// Name of the city.
 	Name string
-	// Invaded is true if another alien already invaded this city.
-	// TODO can be a counter for more flexible simulation.
-	Invaded bool
-	Invader int
-	// Destroyed is true if two alliens fought in this city.
+	// Occupants are ids of the aliens in the city in the order of arrival.
+	// Aliens that died in the city remain occupants of the destroyed city.
+	Occupants []int
+	// Destroyed is true if aliens fought in this city.
 	Destroyed bool
+	// Damage is a number of fights in the city that didn't destroy it.
+	Damage int
+}
+
+// Invaded returns true if any alien is in the city.
+func (c *City) Invaded() bool {
+	return len(c.Occupants) > 0
+}
+
+func (c *City) removeOccupant(id int) {
+	for i, occupant := range c.Occupants {
+		if occupant == id {
+			c.Occupants = append(c.Occupants[:i], c.Occupants[i+1:]...)
+			return
+		}
+	}
+}
+
+// fighters returns occupants that fight when the last occupant arrives. Alien that arrived
+// is the first, others follow in the order of arrival.
+func fighters(occupants []int) []int {
+	last := len(occupants) - 1
+	rst := make([]int, 0, len(occupants))
+	rst = append(rst, occupants[last])
+	return append(rst, occupants[:last]...)
 }
 
 // Route represents route from a city to another city using cardinal direction.
 type Route struct {
-	To        string
-	Direction string
+	To        string    `json:"to"`
+	Direction Direction `json:"direction"`
 }
 
 // NewMap returns new instance of the map.
@@ -89,7 +96,7 @@ func NewMapFromString(data string) *Map {
 	if err != nil {
 		panic(fmt.Sprintf("not a valid map: %v", err))
 	}
-	if n != len(data) {
+	if n != int64(len(data)) {
 		panic(fmt.Sprintf("unable to read whole map, data length is %d, read %d", len(data), n))
 	}
 	return m
@@ -103,6 +110,39 @@ type Map struct {
 	// slice is used to simplify determnistic simulation
 	// note that small slice is equal or better in term of performance then map for key/value sets/gets
 	routes map[string][]Route
+	// headers are kept in the order they were set
+	headers []Header
+}
+
+// SetHeader sets header with a key to value, or updates existing header with the same key.
+// Key can't be empty or have empty spaces. Value can't have new lines, leading or trailing spaces.
+func (m *Map) SetHeader(key, value string) error {
+	if err := validateHeader(key, value); err != nil {
+		return err
+	}
+	for i := range m.headers {
+		if m.headers[i].Key == key {
+			m.headers[i].Value = value
+			return nil
+		}
+	}
+	m.headers = append(m.headers, Header{Key: key, Value: value})
+	return nil
+}
+
+// Header returns value of the header with a key. Returns empty string if header wasn't set.
+func (m *Map) Header(key string) string {
+	for _, h := range m.headers {
+		if h.Key == key {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// Headers returns copy of all headers in the order they were set.
+func (m *Map) Headers() []Header {
+	return append([]Header(nil), m.headers...)
 }
 
 // Size returns number of cities on the map.
@@ -118,28 +158,46 @@ func (m *Map) RoutesSize(from string) int {
 	return 0
 }
 
-// AddCity add city to a map.
+// AddCity add city to a map. City with the same id is replaced.
 func (m *Map) AddCity(city *City) {
 	m.cities[city.ID] = city
 }
 
-// MustAddRoute same as AddRoute but panics is route already exists.
-func (m *Map) MustAddRoute(from, to, direction string) {
+// lookupCity returns a city with the same id as the name. If city doesn't exist new instance is returned,
+// but it is not added to the map. Returns error if existing city has a different name.
+func (m *Map) lookupCity(name string) (city *City, exists bool, err error) {
+	city = NewCity(name)
+	existing, exists := m.cities[city.ID]
+	if !exists {
+		return city, false, nil
+	}
+	if existing.Name != name {
+		return existing, true, fmt.Errorf("%w: %q and %q", ErrNameConflict, existing.Name, name)
+	}
+	return existing, true, nil
+}
+
+// MustAddRoute same as AddRoute but panics if route can't be added.
+func (m *Map) MustAddRoute(from, to string, direction Direction) {
 	if err := m.AddRoute(from, to, direction); err != nil {
 		panic(err.Error())
 	}
 }
 
 // AddRoute from a city to another city via cardinal direction.
-func (m *Map) AddRoute(from, to, direction string) error {
+// Reverse route is added as well.
+func (m *Map) AddRoute(from, to string, direction Direction) error {
+	if !direction.Valid() {
+		return fmt.Errorf("%w: %v", ErrUnknownDirection, direction)
+	}
 	if from == to {
-		return fmt.Errorf("%w: %s adds a route to self", ErrUnexpectedFormat, from)
+		return fmt.Errorf("%w: %s", ErrSelfRoute, from)
 	}
 	selfExists, err := m.verifyRoute(from, to, direction)
 	if err != nil {
 		return err
 	}
-	peerExists, err := m.verifyRoute(to, from, reverseDirection(direction))
+	peerExists, err := m.verifyRoute(to, from, direction.Reverse())
 	if err != nil {
 		return err
 	}
@@ -148,12 +206,12 @@ func (m *Map) AddRoute(from, to, direction string) error {
 		m.addRoute(from, to, direction)
 	}
 	if !peerExists {
-		m.addRoute(to, from, reverseDirection(direction))
+		m.addRoute(to, from, direction.Reverse())
 	}
 	return nil
 }
 
-func (m *Map) verifyRoute(from, to, direction string) (bool, error) {
+func (m *Map) verifyRoute(from, to string, direction Direction) (bool, error) {
 	routes, exist := m.routes[from]
 	if !exist {
 		return false, nil
@@ -164,7 +222,7 @@ func (m *Map) verifyRoute(from, to, direction string) (bool, error) {
 				return true, nil // route already in the table
 			}
 			return true, fmt.Errorf(
-				"adding conflicting route: %v(%v->%v) conflicts with %v(%v->%v)",
+				"%w: %v(%v->%v) conflicts with %v(%v->%v)", ErrConflictingRoute,
 				from, to, direction,
 				from, r.To, direction,
 			)
@@ -173,7 +231,7 @@ func (m *Map) verifyRoute(from, to, direction string) (bool, error) {
 	return false, nil
 }
 
-func (m *Map) addRoute(from, to, direction string) {
+func (m *Map) addRoute(from, to string, direction Direction) {
 	routes, exist := m.routes[from]
 	if !exist {
 		routes = make([]Route, 0, maxRoutes)
@@ -182,6 +240,19 @@ func (m *Map) addRoute(from, to, direction string) {
 	m.routes[from] = append(routes, Route{To: to, Direction: direction})
 }
 
+// clone returns a copy of the map with its own routing table and headers. Cities are shared.
+func (m *Map) clone() *Map {
+	rst := NewMap()
+	for id, city := range m.cities {
+		rst.cities[id] = city
+	}
+	for id, routes := range m.routes {
+		rst.routes[id] = append(make([]Route, 0, maxRoutes), routes...)
+	}
+	rst.headers = append(rst.headers, m.headers...)
+	return rst
+}
+
 // DeleteCity removes city from list of cities and removes all routes.
 func (m *Map) DeleteCity(name string) {
 	delete(m.cities, name)
@@ -196,13 +267,13 @@ func (m *Map) DeleteRoutes(from string) {
 	}
 	delete(m.routes, from)
 	for _, r := range routes {
-		m.deleteRoute(r.To, from, reverseDirection(r.Direction))
+		m.deleteRoute(r.To, from, r.Direction.Reverse())
 	}
 }
 
 // deleteRoute deletes route to a specified city. doesn't restore correctness
 // of the routing table.
-func (m *Map) deleteRoute(from, to, direction string) {
+func (m *Map) deleteRoute(from, to string, direction Direction) {
 	routes, exist := m.routes[from]
 	if !exist {
 		panic(fmt.Sprintf("routing table for %v is out of date", from))
@@ -221,6 +292,63 @@ func (m *Map) deleteRoute(from, to, direction string) {
 	}
 }
 
+// Validate verifies integrity of the routing table:
+// every route has a reverse route in the opposite direction, routes lead only to the cities on the map,
+// directions of the routes from a city are unique and there are at most 4 of them,
+// and routing table has no entries for deleted cities.
+// Returned error wraps ErrCorruptedMap.
+func (m *Map) Validate() error {
+	ids := make([]string, 0, len(m.routes))
+	for id := range m.routes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, from := range ids {
+		routes := m.routes[from]
+		if _, exist := m.cities[from]; !exist {
+			return fmt.Errorf("%w: routes from deleted city %s", ErrCorruptedMap, from)
+		}
+		if len(routes) > maxRoutes {
+			return fmt.Errorf("%w: city %s has %d routes", ErrCorruptedMap, from, len(routes))
+		}
+		for i, r := range routes {
+			if !r.Direction.Valid() {
+				return fmt.Errorf("%w: route from %s has invalid direction %d", ErrCorruptedMap, from, r.Direction)
+			}
+			for _, other := range routes[:i] {
+				if other.Direction == r.Direction {
+					return fmt.Errorf("%w: routes from %s to %s and %s share direction %v",
+						ErrCorruptedMap, from, other.To, r.To, r.Direction)
+				}
+			}
+			if r.To == from {
+				return fmt.Errorf("%w: route from %s to itself", ErrCorruptedMap, from)
+			}
+			if _, exist := m.cities[r.To]; !exist {
+				return fmt.Errorf("%w: route from %s to missing city %s", ErrCorruptedMap, from, r.To)
+			}
+			reverse := Route{To: from, Direction: r.Direction.Reverse()}
+			found := false
+			for _, peer := range m.routes[r.To] {
+				if peer == reverse {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("%w: route from %s to %s via %v doesn't have a reverse route",
+					ErrCorruptedMap, from, r.To, r.Direction)
+			}
+		}
+	}
+	return nil
+}
+
+// Routes returns copy of the routes from a city.
+func (m *Map) Routes(from string) []Route {
+	return append([]Route(nil), m.routes[from]...)
+}
+
 // GetCity queries map for a city using city id.
 func (m *Map) GetCity(id string) *City {
 	return m.cities[id]
@@ -250,107 +378,221 @@ func (m *Map) GetCitiesIDs() []string {
 }
 
 // GetRandomCityFrom picks a random city based on existing routs from a specified city.
-func (m *Map) GetRandomCityFrom(r *rand.Rand, from string) *City {
-	routes, exist := m.routes[from]
+func (m *Map) GetRandomCityFrom(r RNG, from string) *City {
+	route, exist := m.GetRandomRouteFrom(r, from)
 	if !exist {
 		return nil
 	}
+	return m.cities[route.To]
+}
+
+// GetRandomRouteFrom picks a random route from a specified city. Returns false if city has no routes.
+func (m *Map) GetRandomRouteFrom(r RNG, from string) (Route, bool) {
+	routes, exist := m.routes[from]
+	if !exist {
+		return Route{}, false
+	}
 	if len(routes) == 0 {
-		return nil
+		return Route{}, false
 	}
-	route := routes[r.Intn(len(routes))]
-	return m.cities[route.To]
+	return routes[r.Intn(len(routes))], true
+}
+
+// ReadFrom reads from r until io.EOF and adds all cities, routes and headers found.
+// Empty lines and comments are ignored.
+// Any error except io.EOF will be returned. Problems with the content are reported as *ParseError,
+// reading stops at the first one.
+func (m *Map) ReadFrom(r io.Reader) (int64, error) {
+	return m.readFrom(r, false)
+}
+
+// ReadFromLenient is the same as ReadFrom, but it doesn't stop at the first problem with the content.
+// Offending lines or routes are skipped, and all problems are returned as ParseErrors.
+func (m *Map) ReadFromLenient(r io.Reader) (int64, error) {
+	return m.readFrom(r, true)
 }
 
-// ReadFrom reads from r until io.EOF and adds all cities and routes found.
-// Any error except io.EOF will be returned.
-func (m *Map) ReadFrom(r io.Reader) (int, error) {
-	sr := bufio.NewScanner(r)
-	total := 0
+func (m *Map) readFrom(r io.Reader, lenient bool) (int64, error) {
+	var (
+		sr     = bufio.NewScanner(r)
+		total  int64
+		lineno int
+		errs   ParseErrors
+		// headers are allowed only before the first city
+		cities bool
+	)
+	// report collects an error and returns true if reading should be stopped
+	report := func(err *ParseError) bool {
+		err.Line = lineno
+		errs = append(errs, err)
+		return !lenient
+	}
 	for sr.Scan() {
-		total += len(sr.Bytes())
+		lineno++
+		total += int64(len(sr.Bytes()))
 		total++ // scanner splits based on new line byte
-		if len(sr.Bytes()) == 0 {
+		if isBlank(sr.Text()) {
+			continue
+		}
+		if isHeader(sr.Text()) {
+			header, perr := parseHeader(sr.Text())
+			if perr == nil && cities {
+				perr = &ParseError{
+					Column: strings.IndexByte(sr.Text(), headerSign) + 1,
+					Token:  header.Key,
+					Kind:   KindSyntax,
+					Err:    fmt.Errorf("%w: header after the first city", ErrUnexpectedFormat),
+				}
+			}
+			if perr != nil {
+				if report(perr) {
+					return total, perr
+				}
+				continue
+			}
+			_ = m.SetHeader(header.Key, header.Value) // parsed header is always valid
 			continue
 		}
+		cities = true
 
-		// FIXME city names like New York should be valid
-		parts := strings.Split(sr.Text(), " ")
-		if len(parts) == 0 {
+		line, perr := parseLine(sr.Text())
+		if perr != nil {
+			if report(perr) {
+				return total, perr
+			}
 			continue
 		}
-		if len(parts) > 5 {
-			return total, fmt.Errorf("%w: expect to received one city and at most 4 directions per line. got %v", ErrUnexpectedFormat, sr.Text())
+		routes := line.Routes
+		if len(routes) > maxRoutes {
+			extra := routes[maxRoutes]
+			perr := &ParseError{
+				Column: extra.Col,
+				Token:  extra.Raw,
+				Kind:   KindTooManyRoutes,
+				Err: fmt.Errorf("%w: expect at most %d routes per line. got %d",
+					ErrUnexpectedFormat, maxRoutes, len(routes)),
+			}
+			if report(perr) {
+				return total, perr
+			}
+			routes = routes[:maxRoutes]
 		}
 
 		// keep original name to use it for priting, etc
-		// but normalize the id to avoid duplicates on city map
-		city := NewCity(parts[0])
-		m.AddCity(city)
-		for i, r := range parts[1:] {
-			parts = strings.Split(r, "=")
-			if len(parts) != 2 {
-				return total, fmt.Errorf("%w: route %d in %v is in unexpected format", ErrUnexpectedFormat, i+1, sr.Text())
+		// but normalize the id to avoid duplicates on city map.
+		// existing cities are reused, so that state of the cities that are already on the map is preserved
+		city, exists, err := m.lookupCity(line.City)
+		if err != nil {
+			perr := &ParseError{Column: line.CityCol, Token: line.City, Kind: KindNameConflict, Err: err}
+			if report(perr) {
+				return total, perr
+			}
+			continue
+		}
+		if !exists {
+			m.AddCity(city)
+		}
+		for _, r := range routes {
+			perr := &ParseError{Column: r.Col, Token: r.Raw}
+			direction, err := ParseDirection(r.Direction)
+			if err != nil {
+				perr.Kind = KindBadDirection
+				perr.Err = err
+				if report(perr) {
+					return total, perr
+				}
+				continue
+			}
+			peer, exists, err := m.lookupCity(r.Peer)
+			if err != nil {
+				perr.Kind = KindNameConflict
+				perr.Err = err
+				if report(perr) {
+					return total, perr
+				}
+				continue
+			}
+			if err := m.AddRoute(city.ID, peer.ID, direction); err != nil {
+				perr.Kind = KindConflictingRoute
+				if errors.Is(err, ErrSelfRoute) {
+					perr.Kind = KindSelfRoute
+				}
+				perr.Err = err
+				if report(perr) {
+					return total, perr
+				}
+				continue
 			}
-			peer := NewCity(parts[1])
-			// FIXME check if city exists, update may overwrite some state
-			m.AddCity(peer)
-			if err := m.AddRoute(city.ID, peer.ID, strings.ToLower(parts[0])); err != nil {
-				return total, err
+			if !exists {
+				m.AddCity(peer)
 			}
 		}
 	}
-	return total, sr.Err()
+	if err := sr.Err(); err != nil {
+		return total, err
+	}
+	if len(errs) > 0 {
+		return total, errs
+	}
+	return total, nil
 }
 
 // WriteTo writes Map to w in the same format as received. Format:
-// Bar south=Baz north=Foo
+// @name example
+// Bar north=Foo south=Baz
 // Foo north=Bat
+// "New York" east=Foo
 //
+// Headers are written first. Names that can't be read back without quotes (e.g. with empty spaces) are quoted.
+// Names with new lines can't be written in this format, and ErrUnexpectedFormat is returned for them.
+// Routes of every city are written in north, south, east, west order.
 // Order of the output is deterministic, and will be the same in every execution.
 // Any error returned by w.Write will be returned to the caller.
-// Caller SHOULD use buffered writer, as Map.WriteTo performs many small writes.
-func (m *Map) WriteTo(w io.Writer) (int, error) {
+// Caller SHOULD use buffered writer, as Map.WriteTo performs a write per city.
+func (m *Map) WriteTo(w io.Writer) (int64, error) {
 	var (
-		total, n int
-		err      error
+		total int64
+		n     int
+		err   error
+		buf   []byte
+		// routes are written in the order of directions, so that output doesn't depend on the order
+		// in which routes were added, and map that was read back is written exactly the same
+		sorted = make([]Route, 0, maxRoutes)
 	)
-	m.IterateCities(func(city *City, routes []Route) bool {
-		// TODO consider counting required number of bytes and allocating slice ones
-		n, err = w.Write([]byte(city.Name))
-		if err != nil {
-			return false
-		}
-		total += n
-		for _, r := range routes {
-			n, err = w.Write(emptySpace)
-			if err != nil {
-				return false
-			}
-			total += n
-			n, err = w.Write([]byte(r.Direction))
-			if err != nil {
-				return false
-			}
-			total += n
-			n, err = w.Write(equalSign)
-			if err != nil {
-				return false
-			}
-			total += n
-			city := m.GetCity(r.To)
-			n, err = w.Write([]byte(city.Name))
-			if err != nil {
-				return false
-			}
-			total += n
+	for _, h := range m.headers {
+		buf = append(buf[:0], headerSign)
+		buf = append(buf, h.Key...)
+		if len(h.Value) > 0 {
+			buf = append(buf, ' ')
+			buf = append(buf, h.Value...)
 		}
-		n, err = w.Write(newLine)
+		buf = append(buf, '\n')
+		n, err = w.Write(buf)
+		total += int64(n)
 		if err != nil {
+			return total, err
+		}
+	}
+	m.IterateCities(func(city *City, routes []Route) bool {
+		sorted = append(sorted[:0], routes...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i].Direction < sorted[j].Direction
+		})
+		// it is enough to check city names, every route leads to a city that is written on its own line
+		if err = validateName(city.Name); err != nil {
 			return false
 		}
-		total += n
-		return true
+		buf = appendName(buf[:0], city.Name)
+		for _, r := range sorted {
+			buf = append(buf, ' ')
+			buf = append(buf, r.Direction.String()...)
+			buf = append(buf, '=')
+			buf = appendName(buf, m.GetCity(r.To).Name)
+		}
+		buf = append(buf, '\n')
+		n, err = w.Write(buf)
+		total += int64(n)
+		return err == nil
 	})
 	return total, err
 }
@@ -3,6 +3,7 @@ package invasion
 import (
 	"bytes"
 	"errors"
+	"io/ioutil"
 	"math/rand"
 	"testing"
 
@@ -23,14 +24,14 @@ Bar
 	expected.AddCity(NewCity("Tot-H"))
 	expected.AddCity(NewCity("Baz"))
 	expected.AddCity(NewCity("Bar"))
-	expected.AddRoute("foo", "baz", south)
-	expected.AddRoute("foo", "tot-h", north)
-	expected.AddRoute("tot-h", "bar", east)
+	expected.AddRoute("foo", "baz", South)
+	expected.AddRoute("foo", "tot-h", North)
+	expected.AddRoute("tot-h", "bar", East)
 
 	received := NewMap()
 	n, err := received.ReadFrom(buf)
 	require.NoError(t, err)
-	require.Equal(t, len(text), n)
+	require.Equal(t, int64(len(text)), n)
 
 	require.Equal(t, expected, received)
 }
@@ -67,24 +68,195 @@ Baz north=Foo
 	require.Error(t, err)
 }
 
+func TestReadFromParseErrorPosition(t *testing.T) {
+	text := `Foo south=Bar
+Bar east=Baz north=Tot
+`
+	_, err := NewMap().ReadFrom(bytes.NewBufferString(text))
+	var perr *ParseError
+	require.True(t, errors.As(err, &perr), "error is %v", err)
+	require.Equal(t, 2, perr.Line)
+	require.Equal(t, 14, perr.Column)
+	require.Equal(t, "north=Tot", perr.Token)
+	require.Equal(t, KindConflictingRoute, perr.Kind)
+	require.True(t, errors.Is(err, ErrConflictingRoute))
+	require.True(t, errors.Is(err, ErrUnexpectedFormat))
+}
+
+func TestReadFromLenientCollectsErrors(t *testing.T) {
+	text := `Foo south=Bar nrth=Baz
+Bar north=Baz
+"Broken north=Foo
+Baz west=Baz
+Tot north=A south=B east=C west=D north=E
+`
+	m := NewMap()
+	_, err := m.ReadFromLenient(bytes.NewBufferString(text))
+	var errs ParseErrors
+	require.True(t, errors.As(err, &errs), "error is %v", err)
+	require.Len(t, errs, 5)
+
+	expected := []struct {
+		line, column int
+		token        string
+		kind         ParseErrorKind
+	}{
+		{1, 15, "nrth=Baz", KindBadDirection},
+		{2, 5, "north=Baz", KindConflictingRoute},
+		{3, 1, `"Broken`, KindSyntax},
+		{4, 5, "west=Baz", KindSelfRoute},
+		{5, 35, "north=E", KindTooManyRoutes},
+	}
+	for i, e := range expected {
+		require.Equal(t, e.line, errs[i].Line, "error %d", i)
+		require.Equal(t, e.column, errs[i].Column, "error %d", i)
+		require.Equal(t, e.token, errs[i].Token, "error %d", i)
+		require.Equal(t, e.kind, errs[i].Kind, "error %d", i)
+	}
+	require.True(t, errors.Is(err, ErrSelfRoute))
+
+	// valid parts of the map are still loaded
+	require.NotNil(t, m.GetCity("foo"))
+	require.Equal(t, 1, m.RoutesSize("foo"))
+	require.Equal(t, 4, m.RoutesSize("tot"))
+	require.Nil(t, m.GetCity("e"))
+}
+
+func TestReadFromUnknownDirection(t *testing.T) {
+	_, err := NewMap().ReadFrom(bytes.NewBufferString("Foo nrth=Bar\n"))
+	var perr *ParseError
+	require.True(t, errors.As(err, &perr), "error is %v", err)
+	require.Equal(t, KindBadDirection, perr.Kind)
+	require.True(t, errors.Is(err, ErrUnknownDirection))
+}
+
+func TestAddRouteInvalidDirection(t *testing.T) {
+	m := NewMap()
+	m.AddCity(NewCity("Foo"))
+	m.AddCity(NewCity("Bar"))
+	require.True(t, errors.Is(m.AddRoute("foo", "bar", Direction(0)), ErrUnknownDirection))
+	require.True(t, errors.Is(m.AddRoute("foo", "bar", West+1), ErrUnknownDirection))
+	require.Equal(t, 0, m.RoutesSize("foo"))
+	require.Panics(t, func() { m.MustAddRoute("foo", "bar", Direction(0)) })
+}
+
+func TestParseDirection(t *testing.T) {
+	for _, d := range []Direction{North, South, East, West} {
+		parsed, err := ParseDirection(d.String())
+		require.NoError(t, err)
+		require.Equal(t, d, parsed)
+		require.Equal(t, d, d.Reverse().Reverse())
+		require.NotEqual(t, d, d.Reverse())
+	}
+	parsed, err := ParseDirection("NoRtH")
+	require.NoError(t, err)
+	require.Equal(t, North, parsed)
+
+	_, err = ParseDirection("north-west")
+	require.True(t, errors.Is(err, ErrUnknownDirection))
+}
+
+func TestReadFromCommentsAndHeaders(t *testing.T) {
+	text := `# generated for tests
+@name  example map
+@seed 777
+
+   # indented comment
+Foo south=Bar # trailing comment
+"#Hash" west=Foo
+
+Bar
+`
+	m := NewMap()
+	_, err := m.ReadFrom(bytes.NewBufferString(text))
+	require.NoError(t, err)
+	require.Equal(t, []Header{{Key: "name", Value: "example map"}, {Key: "seed", Value: "777"}}, m.Headers())
+	require.Equal(t, "example map", m.Header("name"))
+	require.Equal(t, 2, m.RoutesSize("foo"))
+	require.Equal(t, "#Hash", m.GetCity("#hash").Name)
+
+	buf := bytes.NewBuffer(nil)
+	_, err = m.WriteTo(buf)
+	require.NoError(t, err)
+	require.Equal(t, `@name example map
+@seed 777
+"#Hash" west=Foo
+Bar north=Foo
+Foo south=Bar east="#Hash"
+`, buf.String())
+}
+
+func TestReadFromHeaderAfterCity(t *testing.T) {
+	_, err := NewMap().ReadFrom(bytes.NewBufferString("Foo\n@name late\n"))
+	var perr *ParseError
+	require.True(t, errors.As(err, &perr), "error is %v", err)
+	require.Equal(t, 2, perr.Line)
+	require.Equal(t, KindSyntax, perr.Kind)
+}
+
+func TestSetHeaderValidation(t *testing.T) {
+	m := NewMap()
+	require.NoError(t, m.SetHeader("seed", "1"))
+	require.NoError(t, m.SetHeader("seed", "2"))
+	require.Equal(t, []Header{{Key: "seed", Value: "2"}}, m.Headers())
+	require.Error(t, m.SetHeader("", "1"))
+	require.Error(t, m.SetHeader("two words", "1"))
+	require.Error(t, m.SetHeader("seed", "1\n2"))
+}
+
+func TestReadFromPreservesExistingCities(t *testing.T) {
+	m := NewMapFromString("Foo south=Bar\n")
+	foo := m.GetCity("foo")
+	foo.Occupants = []int{7}
+
+	_, err := m.ReadFrom(bytes.NewBufferString("Foo north=Baz\nBaz west=Bar\n"))
+	require.NoError(t, err)
+	require.True(t, foo == m.GetCity("foo"), "city instance was replaced")
+	require.Equal(t, []int{7}, foo.Occupants)
+	require.Equal(t, 2, m.RoutesSize("foo"))
+	require.Equal(t, 2, m.RoutesSize("bar"))
+}
+
+func TestReadFromNameConflict(t *testing.T) {
+	m := NewMap()
+	_, err := m.ReadFromLenient(bytes.NewBufferString(`Foo south=Bar
+FOO north=Baz
+Baz east=BAR
+`))
+	var errs ParseErrors
+	require.True(t, errors.As(err, &errs), "error is %v", err)
+	require.Len(t, errs, 2)
+	require.Equal(t, KindNameConflict, errs[0].Kind)
+	require.Equal(t, 2, errs[0].Line)
+	require.Equal(t, "FOO", errs[0].Token)
+	require.Equal(t, KindNameConflict, errs[1].Kind)
+	require.Equal(t, 3, errs[1].Line)
+	require.Equal(t, "east=BAR", errs[1].Token)
+	require.True(t, errors.Is(err, ErrNameConflict))
+
+	require.Equal(t, "Foo", m.GetCity("foo").Name)
+	require.Equal(t, "Bar", m.GetCity("bar").Name)
+	require.Equal(t, 0, m.RoutesSize("baz"))
+}
+
 func TestWriteToConsistentWithOriginal(t *testing.T) {
 	buf := bytes.NewBuffer(make([]byte, 0, 50))
 
 	// note that order of insertion follows lexic order of the nodes, simplifies test verification
 	original := NewMap()
 	original.AddCity(NewCity("Bar"))
-	original.AddRoute("tot-h", "bar", east)
+	original.AddRoute("tot-h", "bar", East)
 
 	original.AddCity(NewCity("Baz"))
-	original.AddRoute("foo", "baz", south)
+	original.AddRoute("foo", "baz", South)
 
 	original.AddCity(NewCity("Foo"))
 	original.AddCity(NewCity("Tot-H"))
-	original.AddRoute("foo", "tot-h", north)
+	original.AddRoute("foo", "tot-h", North)
 
 	wn, err := original.WriteTo(buf)
 	require.NoError(t, err)
-	require.Equal(t, buf.Len(), wn)
+	require.Equal(t, int64(buf.Len()), wn)
 
 	recovered := NewMap()
 	rn, err := recovered.ReadFrom(buf)
@@ -94,13 +266,87 @@ func TestWriteToConsistentWithOriginal(t *testing.T) {
 	require.Equal(t, original, recovered)
 }
 
+func TestReadFromQuotedNames(t *testing.T) {
+	text := `"New York" north="Los Angeles" south=Foo
+"San José" west="New York"
+Foo
+"Quote \\ \"Inside\"" east=Foo
+`
+	m := NewMap()
+	_, err := m.ReadFrom(bytes.NewBufferString(text))
+	require.NoError(t, err)
+
+	ny := m.GetCity("new york")
+	require.NotNil(t, ny)
+	require.Equal(t, "New York", ny.Name)
+	require.Equal(t, "Los Angeles", m.GetCity("los angeles").Name)
+	require.Equal(t, "San José", m.GetCity("san josé").Name)
+	require.Equal(t, `Quote \ "Inside"`, m.GetCity(`quote \ "inside"`).Name)
+	require.Equal(t, 3, m.RoutesSize("new york"))
+}
+
+func TestReadFromInvalidQuotes(t *testing.T) {
+	for _, text := range []string{
+		`"New York north=Foo`,
+		`"New"York north=Foo`,
+		`Foo north="Bar\n"`,
+		`Foo north`,
+	} {
+		_, err := NewMap().ReadFrom(bytes.NewBufferString(text))
+		require.True(t, errors.Is(err, ErrUnexpectedFormat), "%s: error is %v", text, err)
+	}
+}
+
+func TestWriteToQuotedRoundTrip(t *testing.T) {
+	m := NewMap()
+	m.AddCity(NewCity("New York"))
+	m.AddCity(NewCity("Los Angeles"))
+	m.AddCity(NewCity(`"Quoted"`))
+	m.AddCity(NewCity("a=b"))
+	m.AddCity(NewCity(`Back\Slash`))
+	m.MustAddRoute("new york", "los angeles", West)
+	m.MustAddRoute("new york", `"quoted"`, North)
+	m.MustAddRoute("a=b", `back\slash`, South)
+
+	first := bytes.NewBuffer(nil)
+	_, err := m.WriteTo(first)
+	require.NoError(t, err)
+	require.Equal(t, `"\"Quoted\"" south="New York"
+"a=b" south=Back\Slash
+Back\Slash north="a=b"
+"Los Angeles" east="New York"
+"New York" north="\"Quoted\"" west="Los Angeles"
+`, first.String())
+
+	recovered := NewMap()
+	_, err = recovered.ReadFrom(bytes.NewBuffer(first.Bytes()))
+	require.NoError(t, err)
+
+	second := bytes.NewBuffer(nil)
+	_, err = recovered.WriteTo(second)
+	require.NoError(t, err)
+	require.Equal(t, first.String(), second.String())
+}
+
+func TestWriteToRejectsNewLines(t *testing.T) {
+	for _, name := range []string{"New\nYork", "New\rYork"} {
+		city := NewCity(name)
+		m := NewMap()
+		m.AddCity(city)
+		m.AddCity(NewCity("Foo"))
+		m.MustAddRoute(city.ID, "foo", North)
+		_, err := m.WriteTo(ioutil.Discard)
+		require.True(t, errors.Is(err, ErrUnexpectedFormat), "%q: error is %v", name, err)
+	}
+}
+
 func TestMapGetRandomCity(t *testing.T) {
 	rng := rand.New(rand.NewSource(0))
 
 	m := NewMap()
 	m.AddCity(NewCity("Bar"))
 	m.AddCity(NewCity("Baz"))
-	m.AddRoute("bar", "baz", east)
+	m.AddRoute("bar", "baz", East)
 
 	baz := m.GetRandomCityFrom(rng, "bar")
 	require.NotNil(t, baz)
@@ -118,8 +364,8 @@ func TestMapCityDeletionPreservesConsistency(t *testing.T) {
 	m.AddCity(NewCity("Bar"))
 	m.AddCity(NewCity("Baz"))
 	m.AddCity(NewCity("Foo"))
-	m.AddRoute("bar", "baz", east)
-	m.AddRoute("foo", "bar", north)
+	m.AddRoute("bar", "baz", East)
+	m.AddRoute("foo", "bar", North)
 
 	expect := `Bar east=Baz
 Baz west=Bar
@@ -131,3 +377,53 @@ Baz west=Bar
 
 	require.Equal(t, expect, buf.String())
 }
+
+func TestMapValidate(t *testing.T) {
+	valid := `Foo north=Bar west=Baz
+Bar east=Baz
+`
+	m := NewMapFromString(valid)
+	require.NoError(t, m.Validate())
+	m.DeleteCity("bar")
+	require.NoError(t, m.Validate())
+
+	for _, tc := range []struct {
+		desc    string
+		corrupt func(*Map)
+	}{
+		{"no reverse route", func(m *Map) {
+			m.routes["bar"] = m.routes["bar"][1:]
+		}},
+		{"reverse route in the wrong direction", func(m *Map) {
+			m.routes["bar"][0].Direction = North
+		}},
+		{"missing city", func(m *Map) {
+			delete(m.cities, "baz")
+			delete(m.routes, "baz")
+		}},
+		{"shared direction", func(m *Map) {
+			m.routes["foo"][1].Direction = North
+		}},
+		{"too many routes", func(m *Map) {
+			m.routes["foo"] = append(m.routes["foo"], m.routes["foo"]...)
+			m.routes["foo"] = append(m.routes["foo"], m.routes["foo"][0])
+		}},
+		{"deleted city", func(m *Map) {
+			m.routes["tot"] = []Route{}
+		}},
+	} {
+		m := NewMapFromString(valid)
+		tc.corrupt(m)
+		require.True(t, errors.Is(m.Validate(), ErrCorruptedMap), tc.desc)
+	}
+}
+
+func TestMapValidateGenerated(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	m := GenerateMap(r, 500, 700)
+	require.NoError(t, m.Validate())
+	for _, id := range m.GetCitiesIDs()[:100] {
+		m.DeleteCity(id)
+	}
+	require.NoError(t, m.Validate())
+}
@@ -27,7 +27,7 @@ func GenerateMap(r *rand.Rand, cities, routes int) *Map {
 	}
 
 	full := map[string]struct{}{} // number of cities with all routes set
-	directions := []string{east, west, north, south}
+	directions := []Direction{East, West, North, South}
 	for i := 0; i < routes; {
 		from := ids[r.Intn(cities)]
 		to := ids[r.Intn(cities)]
@@ -0,0 +1,221 @@
+package invasion
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MapView is a read-only view of the map that is used by movement strategies.
+type MapView interface {
+	GetCity(id string) *City
+	// Routes returns copy of the routes from the city.
+	Routes(from string) []Route
+	GetRandomRouteFrom(r RNG, from string) (Route, bool)
+}
+
+// MovementStrategy picks a route for the alien that is not trapped, there is at least one route
+// from the alien location. Strategy must not modify the alien or the map and must use only r
+// for randomness, so that simulation stays reproducible.
+// Move can be called concurrently for different aliens by ParallelInvasion.
+// Only strategies created by ParseMovementStrategy can be checkpointed.
+type MovementStrategy interface {
+	// Move returns a route from the alien location, or false if alien stays in the city.
+	// Alien that stays in the city makes a move as well.
+	Move(alien *Alien, m MapView, r RNG) (Route, bool)
+}
+
+// Uniform is the default strategy, every route from the city is picked with the same probability.
+func Uniform() MovementStrategy {
+	return uniform{}
+}
+
+type uniform struct{}
+
+func (uniform) Move(alien *Alien, m MapView, r RNG) (Route, bool) {
+	return m.GetRandomRouteFrom(r, alien.Location)
+}
+
+func (uniform) String() string {
+	return "uniform"
+}
+
+// Lazy stays in the city with probability p, otherwise moves same as Uniform.
+func Lazy(p float64) MovementStrategy {
+	return lazy{p: p}
+}
+
+type lazy struct {
+	p float64
+}
+
+func (l lazy) Move(alien *Alien, m MapView, r RNG) (Route, bool) {
+	if randFloat(r) < l.p {
+		return Route{}, false
+	}
+	return m.GetRandomRouteFrom(r, alien.Location)
+}
+
+func (l lazy) String() string {
+	return fmt.Sprintf("lazy:%g", l.p)
+}
+
+// DirectionalBias picks a route in the direction weight times more often than a route in any other direction.
+// Returns error if weight is lower than 1.
+func DirectionalBias(d Direction, weight int) (MovementStrategy, error) {
+	if weight < 1 {
+		return nil, fmt.Errorf("weight of the directional bias must be a positive integer, got %d", weight)
+	}
+	return directionalBias{direction: d, weight: weight}, nil
+}
+
+type directionalBias struct {
+	direction Direction
+	weight    int
+}
+
+func (b directionalBias) Move(alien *Alien, m MapView, r RNG) (Route, bool) {
+	routes := m.Routes(alien.Location)
+	total := 0
+	for _, route := range routes {
+		total += b.weightOf(route)
+	}
+	x := r.Intn(total)
+	for _, route := range routes {
+		x -= b.weightOf(route)
+		if x < 0 {
+			return route, true
+		}
+	}
+	// unreachable, x is always lower than total
+	return Route{}, false
+}
+
+func (b directionalBias) weightOf(route Route) int {
+	if route.Direction == b.direction {
+		return b.weight
+	}
+	return 1
+}
+
+func (b directionalBias) String() string {
+	return fmt.Sprintf("bias:%v:%d", b.direction, b.weight)
+}
+
+// AvoidVisited picks a random route to the city that alien didn't visit, if all cities were visited
+// moves same as Uniform. Visited cities are taken from Alien.History, simulation records all visits
+// of the alien with this strategy if history is not enabled. If history has a limit only the latest
+// visits are avoided.
+func AvoidVisited() MovementStrategy {
+	return avoidVisited{}
+}
+
+type avoidVisited struct{}
+
+func (avoidVisited) Move(alien *Alien, m MapView, r RNG) (Route, bool) {
+	routes := m.Routes(alien.Location)
+	candidates := routes[:0]
+	for _, route := range routes {
+		if !alien.History.Visited(route.To) {
+			candidates = append(candidates, route)
+		}
+	}
+	if len(candidates) == 0 {
+		return m.GetRandomRouteFrom(r, alien.Location)
+	}
+	return candidates[r.Intn(len(candidates))], true
+}
+
+func (avoidVisited) String() string {
+	return "avoid-visited"
+}
+
+// usesHistory returns true if the strategy needs Alien.History.
+func usesHistory(s MovementStrategy) bool {
+	_, ok := s.(avoidVisited)
+	return ok
+}
+
+// seekRadius is a max number of routes to the city with other aliens that SeekNearestAlien looks for,
+// so that cost of the move doesn't grow with the size of the map.
+const seekRadius = 10
+
+// SeekNearestAlien moves towards the nearest city with other aliens, using the first route of the shortest path.
+// Only cities that are at most 10 routes away are searched. If no other alien can be reached within
+// this distance moves same as Uniform.
+func SeekNearestAlien() MovementStrategy {
+	return seekNearest{}
+}
+
+type seekNearest struct{}
+
+func (seekNearest) Move(alien *Alien, m MapView, r RNG) (Route, bool) {
+	// first is a route from the alien location that leads to the city on the shortest path
+	first := map[string]Route{alien.Location: {}}
+	// cities are searched level by level, every level is one route further from the alien
+	level := []string{alien.Location}
+	for distance := 1; distance <= seekRadius && len(level) > 0; distance++ {
+		var next []string
+		for _, from := range level {
+			for _, route := range m.Routes(from) {
+				if _, seen := first[route.To]; seen {
+					continue
+				}
+				if from == alien.Location {
+					first[route.To] = route
+				} else {
+					first[route.To] = first[from]
+				}
+				if city := m.GetCity(route.To); city != nil && city.Invaded() {
+					return first[route.To], true
+				}
+				next = append(next, route.To)
+			}
+		}
+		level = next
+	}
+	return m.GetRandomRouteFrom(r, alien.Location)
+}
+
+func (seekNearest) String() string {
+	return "seek"
+}
+
+// ParseMovementStrategy parses the strategy: uniform, lazy:P, bias:DIRECTION[:WEIGHT], avoid-visited or seek.
+// Default weight for the bias is 2.
+func ParseMovementStrategy(name string) (MovementStrategy, error) {
+	parts := strings.Split(name, ":")
+	switch {
+	case name == "uniform":
+		return Uniform(), nil
+	case name == "avoid-visited":
+		return AvoidVisited(), nil
+	case name == "seek":
+		return SeekNearestAlien(), nil
+	case parts[0] == "lazy" && len(parts) == 2:
+		p, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || p < 0 || p > 1 {
+			return nil, fmt.Errorf("probability in %q must be from 0 to 1", name)
+		}
+		return Lazy(p), nil
+	case parts[0] == "bias" && (len(parts) == 2 || len(parts) == 3):
+		d, err := ParseDirection(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		weight := 2
+		if len(parts) == 3 {
+			weight, err = strconv.Atoi(parts[2])
+			if err != nil || weight < 1 {
+				return nil, fmt.Errorf("weight in %q must be a positive integer", name)
+			}
+		}
+		return DirectionalBias(d, weight)
+	}
+	return nil, fmt.Errorf("unknown movement strategy %q, expected uniform, lazy:P, bias:DIRECTION[:WEIGHT], avoid-visited or seek", name)
+}
+
+// randFloat returns a number in [0, 1) using r.
+func randFloat(r RNG) float64 {
+	return float64(r.Intn(1<<24)) / (1 << 24)
+}
@@ -0,0 +1,192 @@
+package invasion
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUniformMovementIsDefault(t *testing.T) {
+	data := mapText(t, GenerateMap(rand.New(rand.NewSource(20)), 100, 150))
+	for _, engine := range []Engine{EngineSerial, EngineParallel} {
+		expected, collected := &collectSink{}, &collectSink{}
+		_, err := NewInvasion(NewMapFromString(data), WithEngine(engine), WithSeed(20), WithSink(expected)).
+			Run(context.Background())
+		require.NoError(t, err)
+		_, err = NewInvasion(NewMapFromString(data), WithEngine(engine), WithSeed(20), WithSink(collected),
+			WithMovement(Uniform())).Run(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, expected.evs, collected.evs, "engine %v", engine)
+	}
+}
+
+func TestMovementStrategies(t *testing.T) {
+	data := mapText(t, GenerateMap(rand.New(rand.NewSource(21)), 100, 150))
+	for _, spec := range []string{"lazy:0.5", "bias:north:3", "avoid-visited", "seek"} {
+		for _, engine := range []Engine{EngineSerial, EngineParallel} {
+			s, err := ParseMovementStrategy(spec)
+			require.NoError(t, err)
+			requireConsistent(t, fmt.Sprintf("%s engine %v", spec, engine), data, 50, DefaultFightThreshold, nil,
+				WithEngine(engine), WithSeed(21), WithAliens(50), WithMovement(s))
+		}
+	}
+}
+
+func TestAlienMovement(t *testing.T) {
+	data := mapText(t, GenerateMap(rand.New(rand.NewSource(22)), 100, 150))
+	collected := &collectSink{}
+	inv := NewInvasion(NewMapFromString(data), WithSeed(22), WithAliens(20), WithMoves(100),
+		WithAlienMovement(3, Lazy(1)), WithSink(collected))
+	_, err := inv.Run(context.Background())
+	require.NoError(t, err)
+	moved := map[int]bool{}
+	for _, ev := range collected.evs {
+		if ev.Type == AlienMoved {
+			moved[ev.Aliens[0]] = true
+		}
+	}
+	require.False(t, moved[3])
+	require.NotEmpty(t, moved)
+}
+
+func TestDirectionalBias(t *testing.T) {
+	m := NewMapFromString("Foo north=Bar south=Baz east=Qux\n")
+	alien := &Alien{ID: 0, Location: "foo"}
+	r := NewPCG(1, 0)
+	bias, err := DirectionalBias(North, 7)
+	require.NoError(t, err)
+	north := 0
+	for i := 0; i < 1000; i++ {
+		route, move := bias.Move(alien, m, r)
+		require.True(t, move)
+		if route.Direction == North {
+			north++
+		}
+	}
+	// north has weight 7 out of 9
+	require.InDelta(t, 778, north, 60)
+
+	for _, weight := range []int{0, -1} {
+		_, err := DirectionalBias(North, weight)
+		require.Error(t, err, "weight %d", weight)
+	}
+}
+
+func TestAvoidVisited(t *testing.T) {
+	m := NewMapFromString("Foo north=Bar south=Baz\n")
+	alien := &Alien{ID: 0, Location: "foo", History: NewHistory(0)}
+	alien.History.Add(Visit{City: "foo"})
+	s := AvoidVisited()
+	r := NewPCG(1, 0)
+
+	first, _ := s.Move(alien, m, r)
+	alien.Location = first.To
+	alien.History.Add(Visit{City: first.To})
+	route, _ := s.Move(alien, m, r)
+	require.Equal(t, "foo", route.To)
+	alien.Location = route.To
+	alien.History.Add(Visit{City: route.To})
+	route, _ = s.Move(alien, m, r)
+	require.NotEqual(t, first.To, route.To)
+}
+
+func TestAvoidVisitedEnablesHistory(t *testing.T) {
+	data := mapText(t, GenerateMap(rand.New(rand.NewSource(23)), 50, 60))
+	inv := NewInvasion(NewMapFromString(data), WithAliens(10), WithAlienMovement(3, AvoidVisited()))
+	for _, a := range inv.AllAliens() {
+		require.Equal(t, a.ID == 3, a.History != nil, "alien %d", a.ID)
+	}
+	inv = NewInvasion(NewMapFromString(data), WithAliens(10), WithHistory(5), WithMovement(AvoidVisited()))
+	for _, a := range inv.AllAliens() {
+		require.Equal(t, NewHistory(5), a.History)
+	}
+}
+
+func TestMovementCheckpoint(t *testing.T) {
+	// alien that avoids visited cities goes around the ring in one direction, other alien never moves
+	var ring bytes.Buffer
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&ring, "C%d east=C%d\n", i, (i+1)%20)
+	}
+	inv := NewInvasion(NewMapFromString(ring.String()), WithAliens(2), WithMoves(15), WithFightThreshold(3),
+		WithMovement(AvoidVisited()), WithAlienMovement(1, Lazy(1)))
+	_, err := inv.RunSteps(context.Background(), 8)
+	require.NoError(t, err)
+	resumed := resume(t, inv)
+	for _, ev := range runCollect(t, resumed) {
+		require.False(t, ev.Type == AlienMoved && ev.Aliens[0] == 1, "lazy alien moved at step %d", ev.Step)
+	}
+	visited := map[string]bool{}
+	for _, v := range resumed.Alien(0).History.Visits() {
+		require.False(t, visited[v.City], "city %s visited twice", v.City)
+		visited[v.City] = true
+	}
+	require.Len(t, visited, 15)
+}
+
+func TestSeekNearestAlien(t *testing.T) {
+	m := NewMapFromString(`A north=B east=C
+B north=D
+C east=E
+E north=F
+`)
+	alien := &Alien{ID: 0, Location: "a"}
+	other := &Alien{ID: 1}
+	other.Invade(m.GetCity("e"))
+	route, move := SeekNearestAlien().Move(alien, m, NewPCG(1, 0))
+	require.True(t, move)
+	require.Equal(t, Route{To: "c", Direction: East}, route)
+}
+
+func TestSeekNearestAlienRadius(t *testing.T) {
+	// alien in C0 can go south or north, other alien is in the north
+	var line bytes.Buffer
+	line.WriteString("C0 south=S\n")
+	for i := 0; i <= seekRadius; i++ {
+		fmt.Fprintf(&line, "C%d north=C%d\n", i, i+1)
+	}
+	m := NewMapFromString(line.String())
+	alien := &Alien{ID: 0, Location: "c0"}
+	other := &Alien{ID: 1}
+	other.Invade(m.GetCity(fmt.Sprintf("c%d", seekRadius)))
+
+	r := NewPCG(1, 0)
+	for i := 0; i < 20; i++ {
+		route, move := SeekNearestAlien().Move(alien, m, r)
+		require.True(t, move)
+		require.Equal(t, North, route.Direction)
+	}
+
+	// alien that is too far away is not seen
+	other.Leave(m.GetCity(other.Location))
+	other.Invade(m.GetCity(fmt.Sprintf("c%d", seekRadius+1)))
+	directions := map[Direction]bool{}
+	for i := 0; i < 20; i++ {
+		route, _ := SeekNearestAlien().Move(alien, m, r)
+		directions[route.Direction] = true
+	}
+	require.Len(t, directions, 2)
+}
+
+func TestParseMovementStrategy(t *testing.T) {
+	bias, err := DirectionalBias(West, 4)
+	require.NoError(t, err)
+	for _, s := range []MovementStrategy{Uniform(), Lazy(0.25), bias, SeekNearestAlien()} {
+		parsed, err := ParseMovementStrategy(s.(interface{ String() string }).String())
+		require.NoError(t, err)
+		require.Equal(t, s, parsed)
+	}
+	s, err := ParseMovementStrategy("bias:south")
+	require.NoError(t, err)
+	bias, err = DirectionalBias(South, 2)
+	require.NoError(t, err)
+	require.Equal(t, bias, s)
+	for _, name := range []string{"teleport", "lazy", "lazy:2", "bias:up", "bias:north:0", "bias:north:-2"} {
+		_, err := ParseMovementStrategy(name)
+		require.Error(t, err, name)
+	}
+}
@@ -0,0 +1,181 @@
+package invasion
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"sync"
+)
+
+type planKind uint8
+
+const (
+	// planIdle is used if alien can't make progress, e.g. it is trapped or stays in the city.
+	planIdle planKind = iota
+	planSpawn
+	planMove
+	planTrapped
+)
+
+// plan is a transition that alien wants to make in the round.
+type plan struct {
+	alien *Alien
+	kind  planKind
+	// city is a city where alien will spawn
+	city  string
+	route Route
+}
+
+// NewParallelInvasion creates new instance for invasion simulation where aliens make progress in rounds.
+// Every alien has own random generator, NewPCG(seed, id), which makes moves of the alien independent
+// from the number of workers and from other aliens. Fight resolver uses generator of the alien that arrived to the city. Zero or negative workers will use all available CPUs.
+func NewParallelInvasion(m *Map, seed uint64, notifier io.Writer, aliensCount, moves, workers int) *ParallelInvasion {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	pi := &ParallelInvasion{
+		simulation: newSimulation(m, notifier, aliensCount, moves),
+		rngs:       make(map[int]*PCG, aliensCount),
+		workers:    workers,
+	}
+	for id := range pi.aliens {
+		pi.rngs[id] = NewPCG(seed, uint64(id))
+	}
+	return pi
+}
+
+// ParallelInvasion executes simulation in synchronous rounds, where every alien makes a move.
+//
+// Round consists of two phases:
+// 1. planning - workers concurrently pick a transition for every alien, map is not modified.
+// 2. resolution - transitions are applied serially in the order of alien ids. Alien that planned to invade a city
+// which was destroyed earlier in the same round stays where it was, but the move is counted.
+//
+// Result of the simulation depends only on the seed, not on the number of workers.
+type ParallelInvasion struct {
+	simulation
+	rngs    map[int]*PCG
+	workers int
+	plans   []plan
+}
+
+// Run runs invasion until invasion is done, or until context is cancelled.
+// Prints important events to notifier and passes every event to the sinks.
+// Error is returned only if a sink failed, simulation stops at the first such error.
+func (pi *ParallelInvasion) Run(ctx context.Context) (Result, error) {
+	return pi.RunSteps(ctx, -1)
+}
+
+// RunSteps is the same as Run but executes at most n rounds. Negative n doesn't limit rounds.
+func (pi *ParallelInvasion) RunSteps(ctx context.Context, n int) (Result, error) {
+	return pi.runSteps(ctx, n, pi.Step)
+}
+
+// Next executes single round, same as Step.
+//
+// Deprecated: use Step.
+func (pi *ParallelInvasion) Next() []Event {
+	return pi.Step()
+}
+
+// Step executes single round. All state mutations are inside this method.
+// Returns events for every state transition that happened in this round, step of the event is the round number.
+func (pi *ParallelInvasion) Step() (evs []Event) {
+	pi.step++
+	pi.plan()
+
+	for _, p := range pi.plans {
+		alien := p.alien
+		if alien.Dead {
+			// alien was killed by another alien earlier in this round
+			continue
+		}
+		alien.Moves++
+		switch p.kind {
+		case planSpawn:
+			if city := pi.m.GetCity(p.city); city != nil {
+				evs = append(evs, pi.event(AlienSpawned, city, alien.ID))
+				alien.History.Add(Visit{Step: pi.step, City: city.ID})
+				evs = pi.invadeCity(alien, city, pi.rngs[alien.ID], evs)
+			}
+		case planMove:
+			if city := pi.m.GetCity(p.route.To); city != nil {
+				ev := pi.event(AlienMoved, city, alien.ID)
+				ev.From = alien.Location
+				ev.Direction = p.route.Direction
+				evs = append(evs, ev)
+				alien.History.Add(Visit{Step: pi.step, City: city.ID, Direction: p.route.Direction})
+
+				alien.Leave(pi.m.GetCity(alien.Location))
+				evs = pi.invadeCity(alien, city, pi.rngs[alien.ID], evs)
+			}
+		case planTrapped:
+			alien.Trapped = true
+			evs = append(evs, pi.event(AlienTrapped, pi.m.GetCity(alien.Location), alien.ID))
+		}
+		if !alien.Dead && alien.Moves == pi.maxMoves {
+			evs = append(evs, pi.event(AlienExhausted, pi.m.GetCity(alien.Location), alien.ID))
+		}
+	}
+
+	// gc aliens whenever simulation observed their death or they reached max moves
+	order := pi.aliensOrder[:0]
+	for _, id := range pi.aliensOrder {
+		alien := pi.aliens[id]
+		if alien.Dead {
+			delete(pi.aliens, id)
+			pi.fallen[id] = alien
+			delete(pi.rngs, id)
+		} else if alien.Moves < pi.maxMoves {
+			order = append(order, id)
+		}
+	}
+	pi.aliensOrder = order
+	pi.observe(evs)
+	return evs
+}
+
+// plan concurrently picks transitions for all aliens in the order.
+func (pi *ParallelInvasion) plan() {
+	if cap(pi.plans) < len(pi.aliensOrder) {
+		pi.plans = make([]plan, len(pi.aliensOrder))
+	}
+	pi.plans = pi.plans[:len(pi.aliensOrder)]
+
+	size := (len(pi.aliensOrder) + pi.workers - 1) / pi.workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(pi.aliensOrder); start += size {
+		end := start + size
+		if end > len(pi.aliensOrder) {
+			end = len(pi.aliensOrder)
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				pi.plans[i] = pi.planFor(pi.aliens[pi.aliensOrder[i]])
+			}
+		}(start, end)
+	}
+	wg.Wait()
+}
+
+// planFor picks transition for the alien. Must not modify shared state.
+func (pi *ParallelInvasion) planFor(alien *Alien) plan {
+	p := plan{alien: alien}
+	r := pi.rngs[alien.ID]
+	if len(alien.Location) == 0 {
+		if len(pi.citiesOrder) > 0 {
+			p.kind = planSpawn
+			p.city = pi.citiesOrder[r.Intn(len(pi.citiesOrder))]
+		}
+	} else if !alien.Trapped && !alien.Dead {
+		if pi.m.RoutesSize(alien.Location) == 0 {
+			p.kind = planTrapped
+		} else if route, move := pi.movementOf(alien).Move(alien, pi.m, r); move {
+			p.kind = planMove
+			p.route = route
+		}
+	}
+	return p
+}
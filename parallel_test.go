@@ -0,0 +1,62 @@
+package invasion
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParallelInvasionIndependentFromWorkers(t *testing.T) {
+	data := mapText(t, GenerateMap(rand.New(rand.NewSource(9)), 1000, 1500))
+
+	var (
+		first *ParallelInvasion
+		evs   *collectSink
+	)
+	for _, workers := range []int{1, 3, 8} {
+		collected := &collectSink{}
+		inv := NewParallelInvasion(NewMapFromString(data), 9, ioutil.Discard, 200, 100, workers)
+		inv.AddSink(collected)
+		_, err := inv.Run(context.Background())
+		require.NoError(t, err)
+		require.NotEmpty(t, collected.evs)
+		if first == nil {
+			first, evs = inv, collected
+			continue
+		}
+		require.Equal(t, evs.evs, collected.evs, "workers %d", workers)
+		require.Equal(t, mapText(t, first.Map()), mapText(t, inv.Map()), "workers %d", workers)
+	}
+}
+
+func TestParallelInvasionTransitions(t *testing.T) {
+	data := mapText(t, GenerateMap(rand.New(rand.NewSource(10)), 500, 700))
+	m := NewMapFromString(data)
+	inv := NewParallelInvasion(m, 10, ioutil.Discard, 300, 50, 4)
+	verifier := NewTransitionVerifier(NewMapFromString(data), 50, DefaultFightThreshold)
+	for !inv.Done() {
+		for _, ev := range inv.Step() {
+			require.NoError(t, verifier.Verify(ev))
+		}
+		require.NoError(t, VerifyInvariants(m, inv.Aliens()))
+	}
+	require.NoError(t, verifier.Done())
+}
+
+func TestParallelInvasionReplay(t *testing.T) {
+	data := mapText(t, GenerateMap(rand.New(rand.NewSource(12)), 300, 400))
+
+	log := bytes.NewBuffer(nil)
+	inv := NewParallelInvasion(NewMapFromString(data), 12, ioutil.Discard, 100, 100, 4)
+	inv.AddSink(NewJSONLSink(log))
+	_, err := inv.Run(context.Background())
+	require.NoError(t, err)
+
+	replayer := NewReplayer(NewMapFromString(data), DefaultFightThreshold)
+	require.NoError(t, replayer.Replay(NewJSONLReader(log), -1))
+	require.Equal(t, mapText(t, inv.Map()), mapText(t, replayer.Map()))
+}
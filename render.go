@@ -0,0 +1,68 @@
+package invasion
+
+// cityState is a state of the city as it is shown by renderers.
+type cityState uint8
+
+const (
+	stateFree cityState = iota
+	stateInvaded
+	stateTrapped
+	stateDestroyed
+)
+
+// scene is a map prepared for rendering. Cities that were destroyed during invasion are restored
+// together with their routes, so that renderers can show what happened.
+type scene struct {
+	m      *Map
+	states map[string]cityState
+	// aliens are ids of the aliens in the city, ordered by id
+	aliens map[string][]int
+}
+
+// newScene creates a scene from a map, cities that were destroyed and aliens that are still on the map.
+// Original map is not modified.
+func newScene(m *Map, ruins []Ruin, aliens []*Alien) *scene {
+	s := &scene{
+		m:      NewMap(),
+		states: map[string]cityState{},
+		aliens: map[string][]int{},
+	}
+	m.IterateCities(func(c *City, routes []Route) bool {
+		s.m.AddCity(c)
+		if c.Invaded() {
+			s.states[c.ID] = stateInvaded
+		}
+		return true
+	})
+	m.IterateCities(func(c *City, routes []Route) bool {
+		for _, r := range routes {
+			s.m.addRoute(c.ID, r.To, r.Direction)
+		}
+		return true
+	})
+	for _, ruin := range ruins {
+		s.m.AddCity(ruin.City)
+		s.states[ruin.City.ID] = stateDestroyed
+	}
+	for _, ruin := range ruins {
+		for _, r := range ruin.Routes {
+			// routes were consistent before destruction, error is not expected
+			_ = s.m.AddRoute(ruin.City.ID, r.To, r.Direction)
+		}
+	}
+	for _, a := range aliens {
+		if a.Dead || len(a.Location) == 0 {
+			continue
+		}
+		s.aliens[a.Location] = append(s.aliens[a.Location], a.ID)
+		if a.Trapped {
+			s.states[a.Location] = stateTrapped
+		}
+	}
+	return s
+}
+
+// destroyedRoute returns true if route leads from or to the destroyed city.
+func (s *scene) destroyedRoute(from string, r Route) bool {
+	return s.states[from] == stateDestroyed || s.states[r.To] == stateDestroyed
+}
@@ -0,0 +1,157 @@
+package invasion
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapWriteDOT(t *testing.T) {
+	m := NewMapFromString(`Foo south=Baz north="Tot \"H\""
+Baz east=Bar
+`)
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, m.WriteDOT(buf))
+	require.Equal(t, `graph invasion {
+  node [shape=box, style=filled];
+  "bar" [label="Bar", fillcolor=white];
+  "baz" [label="Baz", fillcolor=white];
+  "foo" [label="Foo", fillcolor=white];
+  "tot \"h\"" [label="Tot \"H\"", fillcolor=white];
+  "bar" -- "baz" [taillabel="west", headlabel="east"];
+  "baz" -- "foo" [taillabel="north", headlabel="south"];
+  "foo" -- "tot \"h\"" [taillabel="north", headlabel="south"];
+}
+`, buf.String())
+}
+
+func TestSerialInvasionWriteDOT(t *testing.T) {
+	m := NewMapFromString(`Foo south=Baz
+Baz east=Bar
+`)
+	inv := NewSerialInvasion(m, rand.New(rand.NewSource(1)), ioutil.Discard, 2, 10)
+	foo, baz := m.GetCity("foo"), m.GetCity("baz")
+	inv.invadeCity(inv.aliens[0], baz, inv.r, nil)
+	inv.invadeCity(inv.aliens[1], baz, inv.r, nil)
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, inv.WriteDOT(buf))
+	out := buf.String()
+	require.Contains(t, out, `"baz" [label="Baz", fillcolor=gray, fontcolor=white];`)
+	require.Contains(t, out, `"baz" -- "foo" [taillabel="north", headlabel="south", style=dashed, color=gray];`)
+
+	inv.aliens[2] = &Alien{ID: 2, Trapped: true}
+	inv.aliens[2].Invade(foo)
+	buf.Reset()
+	require.NoError(t, inv.WriteDOT(buf))
+	require.True(t, strings.Contains(buf.String(), `"foo" [label="Foo\naliens: 2", fillcolor=red];`), buf.String())
+}
+
+func TestLayoutGrid(t *testing.T) {
+	m := NewMapFromString(`A east=B south=C
+B south=D
+C east=D
+E
+`)
+	l := NewLayout(m)
+	require.NoError(t, l.Err())
+	require.Equal(t, Point{0, 0}, l.Positions["a"])
+	require.Equal(t, Point{1, 0}, l.Positions["b"])
+	require.Equal(t, Point{0, 1}, l.Positions["c"])
+	require.Equal(t, Point{1, 1}, l.Positions["d"])
+	// separate fragment is placed after an empty cell
+	require.Equal(t, Point{3, 0}, l.Positions["e"])
+	require.Equal(t, 4, l.Width)
+	require.Equal(t, 2, l.Height)
+}
+
+func TestLayoutInconsistentLoop(t *testing.T) {
+	// D is east of C, which is south-east of A, so A can't be north of D
+	m := NewMapFromString(`A east=B
+B south=C
+C east=D
+D north=A
+`)
+	l := NewLayout(m)
+	require.True(t, errors.Is(l.Err(), ErrInconsistentLayout))
+	require.Len(t, l.Inconsistent, 1)
+
+	seen := map[Point]string{}
+	for id, p := range l.Positions {
+		require.NotContains(t, seen, p, "%s and %s at the same position", id, seen[p])
+		seen[p] = id
+	}
+}
+
+func TestLayoutGeneratedMapUniquePositions(t *testing.T) {
+	m := GenerateMap(rand.New(rand.NewSource(7)), 500, 600)
+	l := NewLayout(m)
+	require.Len(t, l.Positions, m.Size())
+	seen := map[Point]string{}
+	for id, p := range l.Positions {
+		require.NotContains(t, seen, p, "%s and %s at the same position", id, seen[p])
+		require.True(t, p.X >= 0 && p.X < l.Width && p.Y >= 0 && p.Y < l.Height)
+		seen[p] = id
+	}
+}
+
+func TestSerialInvasionWriteSVG(t *testing.T) {
+	m := NewMapFromString(`Foo south=Baz
+Baz east=<Bar>
+`)
+	inv := NewSerialInvasion(m, rand.New(rand.NewSource(1)), ioutil.Discard, 2, 10)
+	baz := m.GetCity("baz")
+	inv.invadeCity(inv.aliens[0], baz, inv.r, nil)
+	inv.invadeCity(inv.aliens[1], baz, inv.r, nil)
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, inv.WriteSVG(buf))
+	out := buf.String()
+	require.True(t, strings.HasPrefix(out, "<svg"))
+	require.Contains(t, out, "&lt;Bar&gt;")
+	// destroyed city is crossed out
+	require.Equal(t, 1, strings.Count(out, `stroke-width="2"`))
+	require.Equal(t, 2, strings.Count(out, `stroke-dasharray="4"`))
+}
+
+func TestMapWriteASCII(t *testing.T) {
+	m := NewMapFromString(`"New York" east=Boston south=Philadelphia
+Boston south=Providence
+Philadelphia east=Providence
+Providence west=Philadelphia
+Cape north=Providence west=Boston
+`)
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, m.WriteASCII(buf))
+	require.Equal(t, `New York-------Boston---------Cape
+|              |
+Philadelphia---Providence
+
+1 routes can't be drawn in their direction:
+Cape north=Providence
+`, buf.String())
+}
+
+func TestSerialInvasionWriteASCII(t *testing.T) {
+	m := NewMapFromString(`Foo east=Bar south=Baz
+Baz east=Tot
+`)
+	inv := NewSerialInvasion(m, rand.New(rand.NewSource(1)), ioutil.Discard, 4, 10)
+	inv.invadeCity(inv.aliens[0], m.GetCity("bar"), nil, nil)
+	inv.invadeCity(inv.aliens[1], m.GetCity("bar"), nil, nil)
+	inv.invadeCity(inv.aliens[2], m.GetCity("foo"), nil, nil)
+	inv.invadeCity(inv.aliens[3], m.GetCity("tot"), nil, nil)
+	inv.aliens[3].Trapped = true
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, inv.WriteASCII(buf))
+	require.Equal(t, `Foo@2...xBar
+|
+Baz-----Tot!3
+`, buf.String())
+}
@@ -0,0 +1,272 @@
+package invasion
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ErrInconsistentEvent returned by Replayer if event can't be applied to the current state.
+var ErrInconsistentEvent = errors.New("Inconsistent event")
+
+// NewReplayer creates instance of the replayer for the initial map. Map is updated with every applied event.
+// Threshold must be the same fight threshold that was used by the simulation, lower than 2 uses DefaultFightThreshold.
+func NewReplayer(m *Map, threshold int) *Replayer {
+	if threshold < 2 {
+		threshold = DefaultFightThreshold
+	}
+	return &Replayer{
+		m:         m,
+		threshold: threshold,
+		aliens:    map[int]*Alien{},
+		exhausted: map[int]bool{},
+	}
+}
+
+// Replayer reconstructs state of the simulation by applying events to the initial map.
+//
+// Moves of the alien are updated only by events, so moves of the trapped alien are known
+// only when alien is exhausted. Strength of the aliens is not a part of the events and is not replayed.
+type Replayer struct {
+	m         *Map
+	threshold int
+	aliens    map[int]*Alien
+	exhausted map[int]bool
+
+	step int
+	// arrival is an alien that reached fight threshold in the city, must be followed by a fight in the same step
+	arrival *Alien
+	// fought is the city where the last fight happened, must be followed by destruction or damage in the same step
+	fought *City
+	// fighters are aliens from the last fight, survivors were reported after the fight
+	fighters, survivors []int
+	// next is an event that was read, but wasn't applied, because it belongs to a later step
+	next *Event
+}
+
+// Map returns map with all events applied.
+func (r *Replayer) Map() *Map {
+	return r.m
+}
+
+// Step returns step of the last applied event.
+func (r *Replayer) Step() int {
+	return r.step
+}
+
+// Aliens returns all aliens that were observed in events, including dead, ordered by id.
+func (r *Replayer) Aliens() []*Alien {
+	rst := make([]*Alien, 0, len(r.aliens))
+	for _, a := range r.aliens {
+		rst = append(rst, a)
+	}
+	sort.Slice(rst, func(i, j int) bool {
+		return rst[i].ID < rst[j].ID
+	})
+	return rst
+}
+
+// Replay applies events from the reader until all events up to and including the step are applied.
+// Negative step replays all events. Replay can be called again with a later step to continue.
+func (r *Replayer) Replay(events EventReader, step int) error {
+	for {
+		var ev Event
+		if r.next != nil {
+			ev = *r.next
+			r.next = nil
+		} else {
+			var err error
+			ev, err = events.Next()
+			if errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+		if step >= 0 && ev.Step > step {
+			r.next = &ev
+			return nil
+		}
+		if err := r.Apply(ev); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *Replayer) inconsistent(ev Event, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %v at step %d: %s", ErrInconsistentEvent, ev.Type, ev.Step, fmt.Sprintf(format, args...))
+}
+
+// Apply applies single event. Returns error that wraps ErrInconsistentEvent if event contradicts
+// current state.
+func (r *Replayer) Apply(ev Event) error {
+	if ev.Step < r.step {
+		return r.inconsistent(ev, "event is older than step %d", r.step)
+	}
+	if r.arrival != nil && ev.Type != AliensFought {
+		return r.inconsistent(ev, "alien %d invaded %s without a fight", r.arrival.ID, r.arrival.Location)
+	}
+	if r.fought != nil && ev.Type != CityDestroyed && ev.Type != CityDamaged && ev.Type != AlienSurvived {
+		return r.inconsistent(ev, "city %s was not destroyed after the fight", r.fought.ID)
+	}
+	if len(ev.Aliens) == 0 {
+		return r.inconsistent(ev, "event without aliens")
+	}
+	r.step = ev.Step
+
+	switch ev.Type {
+	case AlienSpawned:
+		if a, exist := r.aliens[ev.Aliens[0]]; exist {
+			return r.inconsistent(ev, "alien %d already spawned in %s", a.ID, a.Location)
+		}
+		a := &Alien{ID: ev.Aliens[0], Faction: ev.faction(0)}
+		r.aliens[a.ID] = a
+		if err := r.updateMoves(ev, a); err != nil {
+			return err
+		}
+		return r.arrive(ev, a)
+	case AlienMoved:
+		a, err := r.activeAlien(ev)
+		if err != nil {
+			return err
+		}
+		if a.Trapped {
+			return r.inconsistent(ev, "alien %d is trapped", a.ID)
+		}
+		if a.Location != ev.From {
+			return r.inconsistent(ev, "alien %d is in %s, not in %s", a.ID, a.Location, ev.From)
+		}
+		if !r.hasRoute(ev.From, Route{To: ev.City, Direction: ev.Direction}) {
+			return r.inconsistent(ev, "no route from %s to %s via %v", ev.From, ev.City, ev.Direction)
+		}
+		if err := r.updateMoves(ev, a); err != nil {
+			return err
+		}
+		a.Leave(r.m.GetCity(a.Location))
+		return r.arrive(ev, a)
+	case AlienTrapped:
+		a, err := r.activeAlien(ev)
+		if err != nil {
+			return err
+		}
+		if a.Trapped || a.Location != ev.City {
+			return r.inconsistent(ev, "alien %d can't be trapped in %s", a.ID, ev.City)
+		}
+		if size := r.m.RoutesSize(a.Location); size > 0 {
+			return r.inconsistent(ev, "alien %d trapped in %s with %d routes", a.ID, a.Location, size)
+		}
+		a.Trapped = true
+		return r.updateMoves(ev, a)
+	case AlienExhausted:
+		a, err := r.activeAlien(ev)
+		if err != nil {
+			return err
+		}
+		if a.Location != ev.City {
+			return r.inconsistent(ev, "alien %d is in %s, not in %s", a.ID, a.Location, ev.City)
+		}
+		r.exhausted[a.ID] = true
+		return r.updateMoves(ev, a)
+	case AliensFought:
+		if r.arrival == nil || r.arrival.ID != ev.Aliens[0] || r.arrival.Location != ev.City {
+			return r.inconsistent(ev, "aliens %v didn't meet in %s", ev.Aliens, ev.City)
+		}
+		city := r.m.GetCity(ev.City)
+		if expected := fighters(city.Occupants); !equalInts(expected, ev.Aliens) {
+			return r.inconsistent(ev, "city %s is invaded by aliens %v, not by %v", city.ID, expected, ev.Aliens)
+		}
+		r.arrival = nil
+		r.fought = city
+		r.fighters = ev.Aliens
+		r.survivors = nil
+		return nil
+	case AlienSurvived:
+		if r.fought == nil || r.fought.ID != ev.City || !containsInt(r.fighters, ev.Aliens[0]) ||
+			containsInt(r.survivors, ev.Aliens[0]) {
+			return r.inconsistent(ev, "alien %d didn't fight in %s", ev.Aliens[0], ev.City)
+		}
+		r.survivors = append(r.survivors, ev.Aliens[0])
+		return nil
+	case CityDestroyed:
+		if r.fought == nil || r.fought.ID != ev.City {
+			return r.inconsistent(ev, "no fight in %s", ev.City)
+		}
+		if !equalInts(r.fighters, ev.Aliens) {
+			return r.inconsistent(ev, "aliens %v fought in %s, not %v", r.fighters, ev.City, ev.Aliens)
+		}
+		if len(r.survivors) > 0 {
+			return r.inconsistent(ev, "aliens %v survived in %s", r.survivors, ev.City)
+		}
+		aliens := make([]*Alien, 0, len(r.fighters))
+		for _, id := range r.fighters {
+			aliens = append(aliens, r.aliens[id])
+		}
+		FightAt(r.fought, aliens...)
+		r.m.DeleteCity(ev.City)
+		r.fought = nil
+		return nil
+	case CityDamaged:
+		if r.fought == nil || r.fought.ID != ev.City {
+			return r.inconsistent(ev, "no fight in %s", ev.City)
+		}
+		if !equalInts(r.fighters, ev.Aliens) {
+			return r.inconsistent(ev, "aliens %v fought in %s, not %v", r.fighters, ev.City, ev.Aliens)
+		}
+		for _, id := range r.fighters {
+			if !containsInt(r.survivors, id) {
+				r.aliens[id].Die()
+				r.fought.removeOccupant(id)
+			}
+		}
+		r.fought.Damage++
+		r.fought = nil
+		return nil
+	}
+	return r.inconsistent(ev, "unknown event")
+}
+
+// activeAlien returns first alien from the event if it can still make moves.
+func (r *Replayer) activeAlien(ev Event) (*Alien, error) {
+	a, exist := r.aliens[ev.Aliens[0]]
+	if !exist {
+		return nil, r.inconsistent(ev, "alien %d didn't spawn", ev.Aliens[0])
+	}
+	if a.Dead {
+		return nil, r.inconsistent(ev, "alien %d is dead", a.ID)
+	}
+	if r.exhausted[a.ID] {
+		return nil, r.inconsistent(ev, "alien %d made all moves", a.ID)
+	}
+	return a, nil
+}
+
+func (r *Replayer) updateMoves(ev Event, a *Alien) error {
+	if ev.Moves < a.Moves {
+		return r.inconsistent(ev, "alien %d moves decreased from %d to %d", a.ID, a.Moves, ev.Moves)
+	}
+	a.Moves = ev.Moves
+	return nil
+}
+
+func (r *Replayer) hasRoute(from string, route Route) bool {
+	for _, existing := range r.m.routes[from] {
+		if existing == route {
+			return true
+		}
+	}
+	return false
+}
+
+// arrive invades a city and waits for the fight if city reached fight threshold.
+func (r *Replayer) arrive(ev Event, a *Alien) error {
+	city := r.m.GetCity(ev.City)
+	if city == nil {
+		return r.inconsistent(ev, "city %s is not on the map", ev.City)
+	}
+	a.Invade(city)
+	if len(city.Occupants) >= r.threshold && !peaceful(city.Occupants, r.aliens) {
+		r.arrival = a
+	}
+	return nil
+}
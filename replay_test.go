@@ -0,0 +1,124 @@
+package invasion
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func mapText(t testing.TB, m *Map) string {
+	buf := bytes.NewBuffer(nil)
+	_, err := m.WriteTo(buf)
+	require.NoError(t, err)
+	return buf.String()
+}
+
+func TestReplayerReconstructsState(t *testing.T) {
+	data := mapText(t, GenerateMap(rand.New(rand.NewSource(3)), 200, 300))
+
+	log := bytes.NewBuffer(nil)
+	inv := NewSerialInvasion(NewMapFromString(data), rand.New(rand.NewSource(3)), ioutil.Discard, 50, 100)
+	inv.AddSink(NewJSONLSink(log))
+	_, err := inv.Run(context.Background())
+	require.NoError(t, err)
+
+	replayer := NewReplayer(NewMapFromString(data), DefaultFightThreshold)
+	require.NoError(t, replayer.Replay(NewJSONLReader(log), -1))
+	require.Equal(t, mapText(t, inv.m), mapText(t, replayer.Map()))
+
+	for _, a := range replayer.Aliens() {
+		original, exist := inv.aliens[a.ID]
+		if !exist {
+			// dead aliens are garbage collected by simulation
+			require.True(t, a.Dead, "alien %d", a.ID)
+			continue
+		}
+		require.Equal(t, original.Location, a.Location, "alien %d", a.ID)
+		require.Equal(t, original.Dead, a.Dead, "alien %d", a.ID)
+		require.Equal(t, original.Trapped, a.Trapped, "alien %d", a.ID)
+		if !a.Trapped {
+			require.Equal(t, original.Moves, a.Moves, "alien %d", a.ID)
+		}
+	}
+}
+
+func TestReplayerIntermediateSteps(t *testing.T) {
+	data := mapText(t, GenerateMap(rand.New(rand.NewSource(4)), 50, 70))
+
+	log := bytes.NewBuffer(nil)
+	inv := NewSerialInvasion(NewMapFromString(data), rand.New(rand.NewSource(4)), ioutil.Discard, 20, 50)
+	inv.AddSink(NewJSONLSink(log))
+	_, err := inv.Run(context.Background())
+	require.NoError(t, err)
+
+	reference := NewSerialInvasion(NewMapFromString(data), rand.New(rand.NewSource(4)), ioutil.Discard, 20, 50)
+	replayer := NewReplayer(NewMapFromString(data), DefaultFightThreshold)
+	events := NewJSONLReader(log)
+	for step := 1; !reference.Done(); step++ {
+		reference.Step()
+		require.NoError(t, replayer.Replay(events, step))
+		require.Equal(t, mapText(t, reference.m), mapText(t, replayer.Map()), "step %d", step)
+	}
+}
+
+func TestReplayerInconsistentEvents(t *testing.T) {
+	data := `Foo north=Bar
+Bar
+Baz
+`
+	for _, tc := range []struct {
+		desc   string
+		events []Event
+	}{
+		{"moved before spawned", []Event{
+			{Type: AlienMoved, Step: 1, Aliens: []int{0}, Moves: 1, From: "foo", City: "bar", Direction: North},
+		}},
+		{"moved without route", []Event{
+			{Type: AlienSpawned, Step: 1, Aliens: []int{0}, Moves: 1, City: "foo"},
+			{Type: AlienMoved, Step: 2, Aliens: []int{0}, Moves: 2, From: "foo", City: "baz", Direction: South},
+		}},
+		{"trapped with routes", []Event{
+			{Type: AlienSpawned, Step: 1, Aliens: []int{0}, Moves: 1, City: "foo"},
+			{Type: AlienTrapped, Step: 2, Aliens: []int{0}, Moves: 2, City: "foo"},
+		}},
+		{"invaded without fight", []Event{
+			{Type: AlienSpawned, Step: 1, Aliens: []int{0}, Moves: 1, City: "foo"},
+			{Type: AlienSpawned, Step: 2, Aliens: []int{1}, Moves: 1, City: "foo"},
+			{Type: AlienSpawned, Step: 3, Aliens: []int{2}, Moves: 1, City: "bar"},
+		}},
+		{"destroyed without fight", []Event{
+			{Type: CityDestroyed, Step: 1, Aliens: []int{0, 1}, City: "foo"},
+		}},
+		{"destroyed by other aliens", []Event{
+			{Type: AlienSpawned, Step: 1, Aliens: []int{0}, Moves: 1, City: "foo"},
+			{Type: AlienSpawned, Step: 2, Aliens: []int{1}, Moves: 1, City: "foo"},
+			{Type: AliensFought, Step: 2, Aliens: []int{1, 0}, City: "foo"},
+			{Type: CityDestroyed, Step: 2, Aliens: []int{1, 2}, City: "foo"},
+		}},
+		{"damaged by other aliens", []Event{
+			{Type: AlienSpawned, Step: 1, Aliens: []int{0}, Moves: 1, City: "foo"},
+			{Type: AlienSpawned, Step: 2, Aliens: []int{1}, Moves: 1, City: "foo"},
+			{Type: AliensFought, Step: 2, Aliens: []int{1, 0}, City: "foo"},
+			{Type: AlienSurvived, Step: 2, Aliens: []int{1}, City: "foo"},
+			{Type: CityDamaged, Step: 2, Aliens: []int{1}, City: "foo"},
+		}},
+		{"moves decreased", []Event{
+			{Type: AlienSpawned, Step: 1, Aliens: []int{0}, Moves: 2, City: "baz"},
+			{Type: AlienTrapped, Step: 2, Aliens: []int{0}, Moves: 1, City: "baz"},
+		}},
+	} {
+		replayer := NewReplayer(NewMapFromString(data), DefaultFightThreshold)
+		var err error
+		for _, ev := range tc.events {
+			if err = replayer.Apply(ev); err != nil {
+				break
+			}
+		}
+		require.True(t, errors.Is(err, ErrInconsistentEvent), "%s: error is %v", tc.desc, err)
+	}
+}
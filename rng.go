@@ -0,0 +1,118 @@
+package invasion
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// RNG is a source of randomness used by the simulation. *rand.Rand implements it, but its state can't be
+// saved and its stream is not guaranteed to be stable between go releases, use PCG instead.
+type RNG interface {
+	// Intn returns a number in [0, n). Panics if n <= 0.
+	Intn(n int) int
+}
+
+// ErrInvalidRNGState returned if PCG state can't be unmarshalled.
+var ErrInvalidRNGState = errors.New("Invalid RNG state")
+
+const (
+	pcgVersion    = 1
+	pcgStateSize  = 17
+	pcgMultiplier = 6364136223846793005
+)
+
+// NewPCG creates PCG generator for the seed and the stream. Generators with the same seed and different
+// streams produce independent sequences.
+//
+// Same seed and stream always produce the same sequence, and therefore the same simulation, across releases.
+// If the algorithm ever changes, marshalled state will get a new version.
+func NewPCG(seed, stream uint64) *PCG {
+	p := &PCG{inc: stream<<1 | 1}
+	p.next()
+	p.state += seed
+	p.next()
+	return p
+}
+
+// PCG is a permuted congruential generator (PCG-XSH-RR with 64-bit state and 32-bit output),
+// see https://www.pcg-random.org.
+type PCG struct {
+	state uint64
+	inc   uint64
+}
+
+func (p *PCG) next() {
+	p.state = p.state*pcgMultiplier + p.inc
+}
+
+// Uint32 returns next 32-bit number of the sequence.
+func (p *PCG) Uint32() uint32 {
+	old := p.state
+	p.next()
+	xorshifted := uint32(((old >> 18) ^ old) >> 27)
+	rot := uint32(old >> 59)
+	return xorshifted>>rot | xorshifted<<((-rot)&31)
+}
+
+// Uint64 returns next 64-bit number, consumes two 32-bit numbers.
+func (p *PCG) Uint64() uint64 {
+	return uint64(p.Uint32())<<32 | uint64(p.Uint32())
+}
+
+// Intn returns uniformly distributed number in [0, n). Panics if n <= 0.
+func (p *PCG) Intn(n int) int {
+	if n <= 0 {
+		panic("invalid argument to Intn")
+	}
+	if uint64(n) <= math.MaxUint32 {
+		return int(p.bounded32(uint32(n)))
+	}
+	// rejection sampling to avoid modulo bias
+	bound := uint64(n)
+	threshold := -bound % bound
+	for {
+		r := p.Uint64()
+		if r >= threshold {
+			return int(r % bound)
+		}
+	}
+}
+
+// bounded32 uses multiplication instead of division, and rejects values that would introduce bias.
+func (p *PCG) bounded32(n uint32) uint32 {
+	threshold := -n % n
+	for {
+		m := uint64(p.Uint32()) * uint64(n)
+		if uint32(m) >= threshold {
+			return uint32(m >> 32)
+		}
+	}
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler. State is prefixed with a version.
+func (p *PCG) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, pcgStateSize)
+	buf[0] = pcgVersion
+	binary.BigEndian.PutUint64(buf[1:], p.state)
+	binary.BigEndian.PutUint64(buf[9:], p.inc)
+	return buf, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *PCG) UnmarshalBinary(data []byte) error {
+	if len(data) != pcgStateSize {
+		return fmt.Errorf("%w: expected %d bytes, got %d", ErrInvalidRNGState, pcgStateSize, len(data))
+	}
+	if data[0] != pcgVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidRNGState, data[0])
+	}
+	inc := binary.BigEndian.Uint64(data[9:])
+	if inc&1 == 0 {
+		return fmt.Errorf("%w: increment must be odd", ErrInvalidRNGState)
+	}
+	p.state = binary.BigEndian.Uint64(data[1:])
+	p.inc = inc
+	return nil
+}
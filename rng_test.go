@@ -0,0 +1,52 @@
+package invasion
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPCGReferenceSequence(t *testing.T) {
+	// first numbers from the reference implementation, pcg32-demo with seed 42 and sequence 54
+	expected := []uint32{0xa15c02b7, 0x7b47f409, 0xba1d3330, 0x83d2f293, 0xbfa4784b, 0xcbed606e}
+	p := NewPCG(42, 54)
+	for _, e := range expected {
+		require.Equal(t, e, p.Uint32())
+	}
+}
+
+func TestPCGIntn(t *testing.T) {
+	p := NewPCG(1, 0)
+	counts := make([]int, 7)
+	for i := 0; i < 7000; i++ {
+		counts[p.Intn(7)]++
+	}
+	for i, c := range counts {
+		require.InDelta(t, 1000, c, 150, "value %d", i)
+	}
+	require.Equal(t, 0, p.Intn(1))
+	require.Panics(t, func() { p.Intn(0) })
+}
+
+func TestPCGMarshalBinary(t *testing.T) {
+	p := NewPCG(7, 3)
+	p.Uint32()
+	state, err := p.MarshalBinary()
+	require.NoError(t, err)
+
+	restored := &PCG{}
+	require.NoError(t, restored.UnmarshalBinary(state))
+	for i := 0; i < 10; i++ {
+		require.Equal(t, p.Intn(100), restored.Intn(100))
+	}
+
+	for _, invalid := range [][]byte{
+		state[:10],
+		append([]byte{2}, state[1:]...),
+		append(append([]byte{}, state[:16]...), 2), // even increment
+	} {
+		err := restored.UnmarshalBinary(invalid)
+		require.True(t, errors.Is(err, ErrInvalidRNGState), "error is %v", err)
+	}
+}
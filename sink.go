@@ -0,0 +1,75 @@
+package invasion
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// EventSink receives every event emitted by the simulation, in the order of emission.
+type EventSink interface {
+	Handle(Event) error
+}
+
+// NewTextSink creates a sink that prints important events to w in human readable form.
+func NewTextSink(w io.Writer) *TextSink {
+	return &TextSink{w: w}
+}
+
+// TextSink prints important events, one per line.
+type TextSink struct {
+	w io.Writer
+}
+
+// Handle prints event if it is important.
+func (s *TextSink) Handle(ev Event) error {
+	if !ev.Important() {
+		return nil
+	}
+	_, err := fmt.Fprintln(s.w, ev)
+	return err
+}
+
+// NewJSONLSink creates a sink that writes every event to w in JSON Lines format:
+//
+// {"type":"spawned","step":1,"aliens":[0],"moves":1,"city":"foo","city_name":"Foo"}
+// {"type":"moved","step":2,"aliens":[0],"moves":2,"city":"bar","city_name":"Bar","from":"foo","direction":"north"}
+//
+// Caller SHOULD use buffered writer.
+func NewJSONLSink(w io.Writer) *JSONLSink {
+	return &JSONLSink{enc: json.NewEncoder(w)}
+}
+
+// JSONLSink writes events as json objects separated by new line.
+type JSONLSink struct {
+	enc *json.Encoder
+}
+
+// Handle writes event.
+func (s *JSONLSink) Handle(ev Event) error {
+	return s.enc.Encode(ev)
+}
+
+// EventReader is a source of events, returns io.EOF when there are no more events.
+type EventReader interface {
+	Next() (Event, error)
+}
+
+// NewJSONLReader creates a reader for events written by JSONLSink.
+func NewJSONLReader(r io.Reader) *JSONLReader {
+	return &JSONLReader{dec: json.NewDecoder(r)}
+}
+
+// JSONLReader reads events in JSON Lines format.
+type JSONLReader struct {
+	dec *json.Decoder
+}
+
+// Next returns next event or io.EOF if there are no more events.
+func (r *JSONLReader) Next() (Event, error) {
+	var ev Event
+	if err := r.dec.Decode(&ev); err != nil {
+		return ev, err
+	}
+	return ev, nil
+}
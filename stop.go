@@ -0,0 +1,145 @@
+package invasion
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StopCondition is a predicate that terminates simulation before map is empty or aliens made all moves.
+type StopCondition interface {
+	// Start is called once, when the condition is added to the simulation. Aliens are the same as returned
+	// by Invasion.Aliens.
+	Start(stats Stats, aliens []*Alien)
+	// Observe is called after every step with events emitted in the step. Returns true if simulation must stop.
+	Observe(step int, evs []Event) bool
+}
+
+// StopWhenDestroyed stops simulation when fraction of the cities is destroyed.
+// Returns error if fraction is not greater than 0 or is greater than 1.
+func StopWhenDestroyed(fraction float64) (StopCondition, error) {
+	if !(fraction > 0 && fraction <= 1) {
+		return nil, fmt.Errorf("fraction of the destroyed cities must be greater than 0 and at most 1, got %g", fraction)
+	}
+	return &destroyedCondition{fraction: fraction}, nil
+}
+
+type destroyedCondition struct {
+	fraction  float64
+	cities    int
+	destroyed int
+}
+
+func (c *destroyedCondition) Start(stats Stats, _ []*Alien) {
+	c.cities = stats.Cities + stats.Destroyed
+	c.destroyed = stats.Destroyed
+}
+
+func (c *destroyedCondition) Observe(_ int, evs []Event) bool {
+	for _, ev := range evs {
+		if ev.Type == CityDestroyed {
+			c.destroyed++
+		}
+	}
+	return c.cities > 0 && float64(c.destroyed) >= c.fraction*float64(c.cities)
+}
+
+func (c *destroyedCondition) String() string {
+	return fmt.Sprintf("%g of the cities destroyed", c.fraction)
+}
+
+// StopWhenCityFalls stops simulation when the city with the name is destroyed.
+func StopWhenCityFalls(name string) StopCondition {
+	return &cityCondition{name: name, id: strings.ToLower(name)}
+}
+
+type cityCondition struct {
+	name, id string
+}
+
+func (c *cityCondition) Start(Stats, []*Alien) {}
+
+func (c *cityCondition) Observe(_ int, evs []Event) bool {
+	for _, ev := range evs {
+		if ev.Type == CityDestroyed && ev.City == c.id {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *cityCondition) String() string {
+	return fmt.Sprintf("%s destroyed", c.name)
+}
+
+// StopWhenOnlyTrapped stops simulation when none of the aliens can move, because every alien that is alive
+// is either trapped or made all moves. Otherwise trapped aliens keep consuming steps until they made all moves.
+func StopWhenOnlyTrapped() StopCondition {
+	return &trappedCondition{}
+}
+
+type trappedCondition struct {
+	// active are aliens that can move
+	active map[int]struct{}
+	// stopped are aliens that are trapped or made all moves, they can't move even if they survive a fight
+	stopped map[int]struct{}
+}
+
+func (c *trappedCondition) Start(_ Stats, aliens []*Alien) {
+	c.active = make(map[int]struct{}, len(aliens))
+	c.stopped = map[int]struct{}{}
+	for _, a := range aliens {
+		if a.Trapped {
+			c.stopped[a.ID] = struct{}{}
+		} else if !a.Dead {
+			c.active[a.ID] = struct{}{}
+		}
+	}
+}
+
+func (c *trappedCondition) Observe(_ int, evs []Event) bool {
+	for _, ev := range evs {
+		switch ev.Type {
+		case AlienTrapped, AlienExhausted:
+			c.stopped[ev.Aliens[0]] = struct{}{}
+			delete(c.active, ev.Aliens[0])
+		case AliensFought:
+			for _, id := range ev.Aliens {
+				delete(c.active, id)
+			}
+		case AlienSurvived:
+			if _, stopped := c.stopped[ev.Aliens[0]]; !stopped {
+				c.active[ev.Aliens[0]] = struct{}{}
+			}
+		}
+	}
+	return len(c.active) == 0
+}
+
+func (c *trappedCondition) String() string {
+	return "only trapped aliens remain"
+}
+
+// StopWhenIdle stops simulation if no event was emitted in the last n steps.
+func StopWhenIdle(n int) StopCondition {
+	return &idleCondition{steps: n}
+}
+
+type idleCondition struct {
+	steps int
+	last  int
+}
+
+func (c *idleCondition) Start(stats Stats, _ []*Alien) {
+	c.last = stats.Steps
+}
+
+func (c *idleCondition) Observe(step int, evs []Event) bool {
+	if len(evs) > 0 {
+		c.last = step
+	}
+	return step-c.last >= c.steps
+}
+
+func (c *idleCondition) String() string {
+	return fmt.Sprintf("no events in %d steps", c.steps)
+}
@@ -0,0 +1,85 @@
+package invasion
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStopWhenDestroyed(t *testing.T) {
+	data := mapText(t, GenerateMap(rand.New(rand.NewSource(16)), 200, 300))
+	for _, engine := range []Engine{EngineSerial, EngineParallel} {
+		cond, err := StopWhenDestroyed(0.1)
+		require.NoError(t, err)
+		inv := NewInvasion(NewMapFromString(data), WithEngine(engine), WithAliens(200), WithStopCondition(cond))
+		rst, err := inv.Run(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, StopConditionMet, rst.Reason, "engine %v", engine)
+		require.Equal(t, cond, rst.Condition)
+		require.True(t, inv.Done())
+
+		// serial engine destroys at most one city in a step, parallel engine can destroy several in a round
+		destroyed := inv.Stats().Destroyed
+		if engine == EngineSerial {
+			require.Equal(t, 20, destroyed)
+		} else {
+			require.GreaterOrEqual(t, destroyed, 20)
+		}
+	}
+
+	for _, fraction := range []float64{0, -0.5, 1.5, math.NaN()} {
+		_, err := StopWhenDestroyed(fraction)
+		require.Error(t, err, "fraction %v", fraction)
+	}
+}
+
+func TestStopWhenCityFalls(t *testing.T) {
+	data := mapText(t, GenerateMap(rand.New(rand.NewSource(17)), 200, 300))
+	inv := NewInvasion(NewMapFromString(data), WithSeed(17), WithAliens(100))
+	_, err := inv.Run(context.Background())
+	require.NoError(t, err)
+	ruins := inv.Ruins()
+	require.True(t, len(ruins) > 3)
+	city := ruins[3].City
+
+	inv = NewInvasion(NewMapFromString(data), WithSeed(17), WithAliens(100), WithStopCondition(StopWhenCityFalls(city.Name)))
+	rst, err := inv.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, StopConditionMet, rst.Reason)
+	require.Len(t, inv.Ruins(), 4)
+	require.Equal(t, city.ID, inv.Ruins()[3].City.ID)
+}
+
+func TestStopWhenOnlyTrapped(t *testing.T) {
+	data := `Foo
+Bar
+Baz
+`
+	for _, engine := range []Engine{EngineSerial, EngineParallel} {
+		inv := NewInvasion(NewMapFromString(data), WithEngine(engine), WithAliens(1), WithMoves(100),
+			WithStopCondition(StopWhenOnlyTrapped()))
+		rst, err := inv.Run(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, Result{Reason: StopConditionMet, Steps: 2, Condition: rst.Condition}, rst, "engine %v", engine)
+		require.Equal(t, 1, inv.Stats().Trapped)
+	}
+}
+
+func TestStopWhenIdle(t *testing.T) {
+	data := `Foo
+Bar
+Baz
+`
+	for _, engine := range []Engine{EngineSerial, EngineParallel} {
+		inv := NewInvasion(NewMapFromString(data), WithEngine(engine), WithAliens(1), WithMoves(100),
+			WithStopCondition(StopWhenIdle(10)))
+		rst, err := inv.Run(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, StopConditionMet, rst.Reason, "engine %v", engine)
+		// spawned in the first step, trapped in the second
+		require.Equal(t, 12, rst.Steps, "engine %v", engine)
+	}
+}
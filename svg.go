@@ -0,0 +1,98 @@
+package invasion
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+)
+
+const (
+	svgCellWidth  = 180
+	svgCellHeight = 80
+	svgBoxWidth   = 140
+	svgBoxHeight  = 44
+	svgMargin     = 20
+)
+
+var svgColors = map[cityState]string{
+	stateFree:      "#ffffff",
+	stateInvaded:   "#ffa500",
+	stateTrapped:   "#ff4040",
+	stateDestroyed: "#a0a0a0",
+}
+
+// WriteSVG draws the map as SVG image. Cities are placed on a grid using NewLayout, routes that
+// can't be drawn in their direction are drawn as dashed curves.
+func (m *Map) WriteSVG(w io.Writer) error {
+	return writeSVG(w, newScene(m, nil, nil))
+}
+
+// WriteSVG draws current state of the invasion as SVG image. Colors are the same as in WriteDOT,
+// destroyed cities are crossed out.
+func (si *simulation) WriteSVG(w io.Writer) error {
+	return writeSVG(w, newScene(si.m, si.ruins, si.located()))
+}
+
+// center returns coordinates of the center of the cell in pixels.
+func svgCenter(p Point) (int, int) {
+	return svgMargin + p.X*svgCellWidth + svgCellWidth/2, svgMargin + p.Y*svgCellHeight + svgCellHeight/2
+}
+
+func writeSVG(w io.Writer, s *scene) error {
+	var (
+		l      = NewLayout(s.m)
+		buf    = bufio.NewWriter(w)
+		width  = 2*svgMargin + l.Width*svgCellWidth
+		height = 2*svgMargin + l.Height*svgCellHeight
+	)
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		width, height, width, height)
+	fmt.Fprintln(buf, `<g font-family="monospace" font-size="12" text-anchor="middle">`)
+
+	inconsistent := map[Edge]struct{}{}
+	for _, e := range l.Inconsistent {
+		inconsistent[e] = struct{}{}
+	}
+	s.m.IterateCities(func(c *City, routes []Route) bool {
+		for _, r := range routes {
+			if c.ID > r.To {
+				continue
+			}
+			x1, y1 := svgCenter(l.Positions[c.ID])
+			x2, y2 := svgCenter(l.Positions[r.To])
+			style := `stroke="#000000"`
+			if s.destroyedRoute(c.ID, r) {
+				style = `stroke="#a0a0a0" stroke-dasharray="4"`
+			}
+			if _, exist := inconsistent[Edge{From: c.ID, Route: r}]; exist {
+				// curve to the side, so that it is visible even if it crosses other cities
+				cx, cy := (x1+x2)/2+(y2-y1)/4, (y1+y2)/2-(x2-x1)/4
+				fmt.Fprintf(buf, `<path d="M %d %d Q %d %d %d %d" fill="none" %s stroke-dasharray="2"/>`+"\n",
+					x1, y1, cx, cy, x2, y2, style)
+				continue
+			}
+			fmt.Fprintf(buf, `<line x1="%d" y1="%d" x2="%d" y2="%d" %s/>`+"\n", x1, y1, x2, y2, style)
+		}
+		return true
+	})
+	s.m.IterateCities(func(c *City, _ []Route) bool {
+		x, y := svgCenter(l.Positions[c.ID])
+		left, top := x-svgBoxWidth/2, y-svgBoxHeight/2
+		fmt.Fprintf(buf, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s" stroke="#000000"/>`+"\n",
+			left, top, svgBoxWidth, svgBoxHeight, svgColors[s.states[c.ID]])
+		fmt.Fprintf(buf, `<text x="%d" y="%d">%s</text>`+"\n", x, y-4, html.EscapeString(c.Name))
+		if ids := s.aliens[c.ID]; len(ids) > 0 {
+			fmt.Fprintf(buf, `<text x="%d" y="%d">%s</text>`+"\n", x, y+12, aliensLabel(ids))
+		}
+		if s.states[c.ID] == stateDestroyed {
+			fmt.Fprintf(buf, `<path d="M %d %d L %d %d M %d %d L %d %d" stroke="#000000" stroke-width="2"/>`+"\n",
+				left, top, left+svgBoxWidth, top+svgBoxHeight,
+				left+svgBoxWidth, top, left, top+svgBoxHeight)
+		}
+		return true
+	})
+	fmt.Fprintln(buf, "</g>")
+	fmt.Fprintln(buf, "</svg>")
+	return buf.Flush()
+}
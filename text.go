@@ -0,0 +1,346 @@
+package invasion
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ParseErrorKind classifies errors found by Map.ReadFrom.
+type ParseErrorKind uint8
+
+const (
+	// KindSyntax is used when line can't be split into a city and routes.
+	KindSyntax ParseErrorKind = iota + 1
+	// KindBadDirection is used when route has unknown direction.
+	KindBadDirection
+	// KindSelfRoute is used when city has a route to itself.
+	KindSelfRoute
+	// KindConflictingRoute is used when route conflicts with a route that is already on the map.
+	KindConflictingRoute
+	// KindTooManyRoutes is used when line has more than 4 routes.
+	KindTooManyRoutes
+	// KindNameConflict is used when name differs from the name of the city with the same id.
+	KindNameConflict
+)
+
+func (k ParseErrorKind) String() string {
+	switch k {
+	case KindSyntax:
+		return "syntax error"
+	case KindBadDirection:
+		return "bad direction"
+	case KindSelfRoute:
+		return "self route"
+	case KindConflictingRoute:
+		return "conflicting route"
+	case KindTooManyRoutes:
+		return "too many routes"
+	case KindNameConflict:
+		return "name conflict"
+	default:
+		return fmt.Sprintf("unknown(%d)", k)
+	}
+}
+
+// ParseError describes a problem at the specific position of the text map.
+type ParseError struct {
+	// Line is a 1-based line number.
+	Line int
+	// Column is a 1-based byte offset of the Token in the line.
+	Column int
+	// Token is an offending part of the line.
+	Token string
+	Kind  ParseErrorKind
+	// Err is an underlying error, always wraps ErrUnexpectedFormat.
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %v at %q: %v", e.Line, e.Column, e.Kind, e.Token, e.Err)
+}
+
+// Unwrap returns underlying error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ParseErrors is a list of errors collected by Map.ReadFromLenient.
+type ParseErrors []*ParseError
+
+func (errs ParseErrors) Error() string {
+	if len(errs) == 1 {
+		return errs[0].Error()
+	}
+	return fmt.Sprintf("%d errors, first: %v", len(errs), errs[0])
+}
+
+// Is returns true if any of the collected errors matches the target.
+func (errs ParseErrors) Is(target error) bool {
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// text format of the map is line oriented, each line has a city name followed by routes:
+//
+// @name example
+// # comment
+// Foo north=Bar south="New York" # trailing comment
+// "New York" north="Los Angeles" west=Foo
+//
+// Name can be written bare, in which case it ends with the first empty space, or in double quotes.
+// Inside the quotes backslash escapes double quote and backslash itself.
+// Everything after # at the start of the word is a comment. Lines that start with @ are headers,
+// they are allowed only before the first city.
+
+const (
+	quote         = '"'
+	commentSign   = '#'
+	headerSign    = '@'
+	headerInvalid = " \t\r\n"
+)
+
+// Header is a key/value pair that annotates the map, e.g. name or seed that was used to generate the map.
+type Header struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func validateHeader(key, value string) error {
+	if len(key) == 0 || strings.ContainsAny(key, headerInvalid) {
+		return fmt.Errorf("%w: header key %q can't be empty or have empty spaces", ErrUnexpectedFormat, key)
+	}
+	if strings.ContainsAny(value, "\r\n") || strings.TrimSpace(value) != value {
+		return fmt.Errorf("%w: header value %q can't have new lines, leading or trailing spaces", ErrUnexpectedFormat, value)
+	}
+	return nil
+}
+
+// isBlank returns true if line has only empty spaces or a comment.
+func isBlank(line string) bool {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ', '\t', '\r':
+		case commentSign:
+			return true
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isHeader returns true if line starts with a header sign.
+func isHeader(line string) bool {
+	line = strings.TrimLeft(line, " \t")
+	return len(line) > 0 && line[0] == headerSign
+}
+
+// parseHeader parses line in the format "@key value". Value is everything after the key without
+// leading and trailing spaces.
+func parseHeader(line string) (Header, *ParseError) {
+	start := strings.IndexByte(line, headerSign)
+	line = strings.TrimRight(line, " \t\r")
+	rest := line[start+1:]
+	key := rest
+	value := ""
+	if idx := strings.IndexAny(rest, " \t"); idx >= 0 {
+		key = rest[:idx]
+		value = strings.TrimSpace(rest[idx:])
+	}
+	if len(key) == 0 {
+		return Header{}, &ParseError{
+			Column: start + 1,
+			Token:  string(headerSign),
+			Kind:   KindSyntax,
+			Err:    fmt.Errorf("%w: header without a key", ErrUnexpectedFormat),
+		}
+	}
+	return Header{Key: key, Value: value}, nil
+}
+
+// textRoute is a route as it was written in the text format. Peer is a name of the city, not an id.
+type textRoute struct {
+	Direction string
+	Peer      string
+	// Raw is the route as it was written.
+	Raw string
+	// Col is a 1-based byte offset of the route in the line.
+	Col int
+}
+
+// textLine is a parsed line of the text format.
+type textLine struct {
+	City string
+	// CityCol is a 1-based byte offset of the city name in the line.
+	CityCol int
+	Routes  []textRoute
+}
+
+// lineScanner splits single line of the text format into city name and routes.
+type lineScanner struct {
+	line string
+	pos  int
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+func (s *lineScanner) skipSpace() {
+	for s.pos < len(s.line) && isSpace(s.line[s.pos]) {
+		s.pos++
+	}
+}
+
+func (s *lineScanner) done() bool {
+	return s.pos >= len(s.line)
+}
+
+// tokenAt returns all bytes from start until the first empty space.
+func (s *lineScanner) tokenAt(start int) string {
+	end := start
+	for end < len(s.line) && !isSpace(s.line[end]) {
+		end++
+	}
+	return s.line[start:end]
+}
+
+// syntaxError creates error for a token that starts at start.
+func (s *lineScanner) syntaxError(start int, format string, args ...interface{}) *ParseError {
+	return &ParseError{
+		Column: start + 1,
+		Token:  s.tokenAt(start),
+		Kind:   KindSyntax,
+		Err:    fmt.Errorf("%w: %s", ErrUnexpectedFormat, fmt.Sprintf(format, args...)),
+	}
+}
+
+// readName reads either quoted or bare name. If stopAtEqual is true bare name can't contain equal sign.
+func (s *lineScanner) readName(stopAtEqual bool) (string, *ParseError) {
+	if s.done() {
+		return "", s.syntaxError(s.pos, "expected a name")
+	}
+	if s.line[s.pos] == quote {
+		return s.readQuoted()
+	}
+	start := s.pos
+	for ; s.pos < len(s.line) && !isSpace(s.line[s.pos]); s.pos++ {
+		if stopAtEqual && s.line[s.pos] == '=' {
+			return "", s.syntaxError(start, "unexpected '=' in the name")
+		}
+	}
+	return s.line[start:s.pos], nil
+}
+
+func (s *lineScanner) readQuoted() (string, *ParseError) {
+	start := s.pos
+	s.pos++ // opening quote
+	var b strings.Builder
+	for ; s.pos < len(s.line); s.pos++ {
+		c := s.line[s.pos]
+		switch c {
+		case '\\':
+			s.pos++
+			if s.pos == len(s.line) || (s.line[s.pos] != quote && s.line[s.pos] != '\\') {
+				return "", s.syntaxError(start, "invalid escape at column %d", s.pos)
+			}
+			b.WriteByte(s.line[s.pos])
+		case quote:
+			s.pos++
+			if !s.done() && !isSpace(s.line[s.pos]) {
+				return "", s.syntaxError(start, "expected empty space after closing quote")
+			}
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", s.syntaxError(start, "quote is not closed")
+}
+
+func (s *lineScanner) readRoute() (textRoute, *ParseError) {
+	route := textRoute{Col: s.pos + 1}
+	start := s.pos
+	for ; s.pos < len(s.line) && s.line[s.pos] != '='; s.pos++ {
+		if isSpace(s.line[s.pos]) {
+			break
+		}
+	}
+	if s.done() || s.line[s.pos] != '=' {
+		return route, s.syntaxError(start, "route is not in direction=city format")
+	}
+	route.Direction = s.line[start:s.pos]
+	s.pos++
+	peer, err := s.readName(true)
+	if err != nil {
+		err.Column = route.Col
+		err.Token = s.tokenAt(start)
+		return route, err
+	}
+	route.Peer = peer
+	route.Raw = s.line[start:s.pos]
+	return route, nil
+}
+
+// parseLine parses a line with a city and routes. Returned error doesn't have a line number.
+func parseLine(line string) (textLine, *ParseError) {
+	var (
+		rst textLine
+		err *ParseError
+		s   = lineScanner{line: strings.TrimSuffix(line, "\r")}
+	)
+	s.skipSpace()
+	rst.CityCol = s.pos + 1
+	rst.City, err = s.readName(false)
+	if err != nil {
+		return rst, err
+	}
+	for s.skipSpace(); !s.done() && s.line[s.pos] != commentSign; s.skipSpace() {
+		route, err := s.readRoute()
+		if err != nil {
+			return rst, err
+		}
+		rst.Routes = append(rst.Routes, route)
+	}
+	return rst, nil
+}
+
+// validateName returns error if name can't be written in the text format.
+func validateName(name string) error {
+	if strings.ContainsAny(name, "\r\n") {
+		return fmt.Errorf("%w: city name %q can't have new lines", ErrUnexpectedFormat, name)
+	}
+	return nil
+}
+
+// needsQuotes returns true if name can't be written without quotes.
+func needsQuotes(name string) bool {
+	if len(name) == 0 {
+		return true
+	}
+	switch name[0] {
+	case quote, commentSign, headerSign:
+		return true
+	}
+	return strings.ContainsAny(name, " \t=")
+}
+
+// appendName appends name to buf and quotes it if necessary.
+func appendName(buf []byte, name string) []byte {
+	if !needsQuotes(name) {
+		return append(buf, name...)
+	}
+	buf = append(buf, quote)
+	for i := 0; i < len(name); i++ {
+		if name[i] == quote || name[i] == '\\' {
+			buf = append(buf, '\\')
+		}
+		buf = append(buf, name[i])
+	}
+	return append(buf, quote)
+}